@@ -1,4 +1,3 @@
-// TODO(miku): parallel processing.
 package main
 
 import (
@@ -9,15 +8,12 @@ import (
 	"io"
 	"log"
 	"os"
-	"sort"
+	"runtime"
 	"strings"
 
 	"github.com/miku/holdings"
-	"github.com/miku/holdings/google"
-	"github.com/miku/holdings/kbart"
-	"github.com/miku/holdings/ovid"
 	"github.com/miku/istools"
-	"github.com/miku/span/container"
+	"github.com/miku/istools/coverage"
 	"github.com/miku/span/finc"
 )
 
@@ -27,6 +23,9 @@ func main() {
 	permissiveMode := flag.Bool("permissive", false, "if we cannot check, we allow")
 	ignoreUnmarshalErrors := flag.Bool("ignore-unmarshal-errors", false, "keep using what could be unmarshalled")
 	version := flag.Bool("version", false, "show version")
+	output := flag.String("o", "tsv", "output format, tsv or json")
+	numWorkers := flag.Int("w", runtime.NumCPU(), "number of workers")
+	batchSize := flag.Int("b", 20000, "batch size")
 
 	flag.Parse()
 
@@ -39,111 +38,59 @@ func main() {
 		log.Fatal("holding -file required")
 	}
 
-	var r *bufio.Reader
-	if flag.NArg() == 0 {
-		r = bufio.NewReader(os.Stdin)
-	} else {
+	if *output != "tsv" && *output != "json" {
+		log.Fatalf("invalid output format: %s", *output)
+	}
+
+	var r io.Reader = os.Stdin
+	if flag.NArg() > 0 {
 		file, err := os.Open(flag.Arg(0))
 		if err != nil {
 			log.Fatal(err)
 		}
-		r = bufio.NewReader(file)
+		defer file.Close()
+		r = file
 	}
 
-	hfile, err := os.Open(*filename)
+	entries, err := coverage.ReadFile(*filename, *format)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	var hr holdings.File
-
-	switch *format {
-	case "kbart":
-		hr = kbart.NewReader(hfile)
-	case "ovid":
-		hr = ovid.NewReader(hfile)
-	case "google":
-		hr = google.NewReader(hfile)
-	default:
-		log.Fatalf("invalid holding file format: %s", *format)
-	}
-
-	entries, err := hr.ReadAll()
-	if err != nil {
-		switch err.(type) {
-		case holdings.ParseError:
-			if *ignoreUnmarshalErrors {
-				log.Println(err)
-			} else {
-				log.Fatal(err)
-			}
-		default:
+		if _, ok := err.(holdings.ParseError); ok && *ignoreUnmarshalErrors {
+			log.Println(err)
+		} else {
 			log.Fatal(err)
 		}
 	}
 
-	for {
-		b, err := r.ReadBytes('\n')
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Fatal(err)
-		}
+	checker := coverage.Checker{Entries: entries, Permissive: *permissiveMode}
+
+	p := istools.NewProcessor(func(b []byte) ([]byte, error) {
 		var is finc.IntermediateSchema
 		if err := json.Unmarshal(b, &is); err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		signature := holdings.Signature{
-			Date:   is.Date.Format("2006-01-02"),
-			Volume: is.Volume,
-			Issue:  is.Issue,
-		}
-
-		// validate record, if at least one license allows this item
-		var valid bool
-		var messages = container.NewStringSet()
-
-	LOOP:
-		for _, issn := range append(is.ISSN, is.EISSN...) {
-			licenses := entries.Licenses(issn)
-
-			if len(licenses) == 0 {
-				messages.Add(fmt.Sprintf("ISSN not in holdings"))
-			}
-
-			if len(licenses) == 0 && *permissiveMode {
-				messages.Add("PERMISSIVE_OK")
-				valid = true
-				break LOOP
+		verdict := checker.Check(is)
+		switch *output {
+		case "json":
+			bs, err := json.Marshal(map[string]interface{}{
+				"id":      is.RecordID,
+				"valid":   verdict.Valid,
+				"reasons": verdict.Reasons,
+			})
+			if err != nil {
+				return nil, err
 			}
-
-			for _, license := range licenses {
-				if err := license.Covers(signature); err != nil {
-					messages.Add(err.Error())
-				} else {
-					if err := license.TimeRestricted(is.Date); err != nil {
-						messages.Add(err.Error())
-					} else {
-						messages.Add("OK")
-						valid = true
-						break LOOP
-					}
-				}
-			}
-		}
-
-		if len(is.ISSN) == 0 && len(is.EISSN) == 0 {
-			messages.Add("Record has no ISSN")
+			return append(bs, '\n'), nil
+		default:
+			return []byte(fmt.Sprintf("%s\t%v\t%v\n", is.RecordID, verdict.Valid, strings.Join(verdict.Messages(), ", "))), nil
 		}
+	})
+	p.NumWorkers = *numWorkers
+	p.BatchSize = *batchSize
 
-		if len(is.ISSN) == 0 && len(is.EISSN) == 0 && *permissiveMode {
-			messages.Add("PERMISSIVE_OK")
-			valid = true
-		}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
 
-		values := messages.Values()
-		sort.Strings(values)
-		fmt.Printf("%s\t%v\t%v\n", is.RecordID, valid, strings.Join(values, ", "))
+	if err := p.Run(r, w); err != nil {
+		log.Fatal(err)
 	}
 }
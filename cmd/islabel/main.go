@@ -1,4 +1,3 @@
-// TODO(miku): parallel processing.
 package main
 
 import (
@@ -9,16 +8,76 @@ import (
 	"io"
 	"log"
 	"os"
+	"runtime"
+	"strings"
 
 	"github.com/miku/holdings"
-	"github.com/miku/holdings/google"
-	"github.com/miku/holdings/kbart"
-	"github.com/miku/holdings/ovid"
 	"github.com/miku/istools"
+	"github.com/miku/istools/coverage"
 	"github.com/miku/span/container"
 	"github.com/miku/span/finc"
 )
 
+// explanation tells, why a record got its labels.
+type explanation struct {
+	ID     string          `json:"id"`
+	Labels []string        `json:"labels"`
+	Tree   []istools.Trace `json:"tree,omitempty"`
+	Checks []check         `json:"checks,omitempty"`
+}
+
+// check is the verdict of a holdings file given by -file or -x.
+type check struct {
+	Label   string           `json:"label"`
+	Verdict coverage.Verdict `json:"verdict"`
+}
+
+// labeledChecker attaches its label to covered records.
+type labeledChecker struct {
+	label   string
+	checker coverage.Checker
+}
+
+// readEntries reads a holdings file in a given format.
+func readEntries(filename, format string, ignoreUnmarshalErrors bool) holdings.Entries {
+	entries, err := coverage.ReadFile(filename, format)
+	if err != nil {
+		if _, ok := err.(holdings.ParseError); ok && ignoreUnmarshalErrors {
+			log.Println(err)
+		} else {
+			log.Fatal(err)
+		}
+	}
+	return entries
+}
+
+// addLabel appends a label, if it is not already attached.
+func addLabel(is *finc.IntermediateSchema, label string) {
+	for _, l := range is.Labels {
+		if l == label {
+			return
+		}
+	}
+	is.Labels = append(is.Labels, label)
+}
+
+// applyLabels attaches the labels of all matching tree nodes and holdings
+// files to a record and returns the verdicts of the holdings files.
+func applyLabels(is *finc.IntermediateSchema, tree istools.Tree, labeled []labeledChecker) []check {
+	for _, isil := range tree.Apply(*is) {
+		addLabel(is, isil)
+	}
+	var checks []check
+	for _, lc := range labeled {
+		verdict := lc.checker.Check(*is)
+		if verdict.Valid {
+			addLabel(is, lc.label)
+		}
+		checks = append(checks, check{Label: lc.label, Verdict: verdict})
+	}
+	return checks
+}
+
 func main() {
 	filename := flag.String("file", "", "path to holdings file")
 	format := flag.String("format", "kbart", "holding file format, kbart, google, ovid")
@@ -26,6 +85,10 @@ func main() {
 	ignoreUnmarshalErrors := flag.Bool("ignore-unmarshal-errors", false, "keep using what could be unmarshalled")
 	version := flag.Bool("version", false, "show version")
 	label := flag.String("label", "X", "label to add")
+	conf := flag.String("conf", "", "path to JSON filter tree, keyed by ISIL")
+	numWorkers := flag.Int("w", runtime.NumCPU(), "number of workers")
+	batchSize := flag.Int("b", 20000, "batch size")
+	explain := flag.String("explain", "", "comma separated record ids, print evaluation traces as JSON instead of records")
 
 	var tags istools.TagSlice
 	flag.Var(&tags, "x", "ISIL:/path/to/kbart.txt")
@@ -37,125 +100,93 @@ func main() {
 		os.Exit(0)
 	}
 
-	if *filename == "" {
-		log.Fatal("holding -file required")
+	if *filename == "" && *conf == "" && len(tags) == 0 {
+		log.Fatal("holding -file, -x ISIL:/path/to/file or filter tree -conf required")
 	}
 
-	var r *bufio.Reader
-	if flag.NArg() == 0 {
-		r = bufio.NewReader(os.Stdin)
-	} else {
+	var r io.Reader = os.Stdin
+	if flag.NArg() > 0 {
 		file, err := os.Open(flag.Arg(0))
 		if err != nil {
 			log.Fatal(err)
 		}
-		r = bufio.NewReader(file)
+		defer file.Close()
+		r = file
 	}
 
-	hfile, err := os.Open(*filename)
-	if err != nil {
-		log.Fatal(err)
+	var tree istools.Tree
+	if *conf != "" {
+		var err error
+		if tree, err = istools.ReadTree(*conf); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	var hr holdings.File
-
-	switch *format {
-	case "kbart":
-		hr = kbart.NewReader(hfile)
-	case "ovid":
-		hr = ovid.NewReader(hfile)
-	case "google":
-		hr = google.NewReader(hfile)
-	default:
-		log.Fatalf("invalid holding file format: %s", *format)
+	var labeled []labeledChecker
+	if *filename != "" {
+		labeled = append(labeled, labeledChecker{
+			label: *label,
+			checker: coverage.Checker{
+				Entries:    readEntries(*filename, *format, *ignoreUnmarshalErrors),
+				Permissive: *permissiveMode,
+			},
+		})
+	}
+	for _, tag := range tags {
+		labeled = append(labeled, labeledChecker{
+			label: tag.Tag,
+			checker: coverage.Checker{
+				Entries:    readEntries(tag.Value, *format, *ignoreUnmarshalErrors),
+				Permissive: *permissiveMode,
+			},
+		})
 	}
 
-	entries, err := hr.ReadAll()
-	if err != nil {
-		switch err.(type) {
-		case holdings.ParseError:
-			if *ignoreUnmarshalErrors {
-				log.Println(err)
-			} else {
-				log.Fatal(err)
-			}
-		default:
-			log.Fatal(err)
+	ids := container.NewStringSet()
+	for _, id := range strings.Split(*explain, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids.Add(id)
 		}
 	}
 
-	for {
-		b, err := r.ReadBytes('\n')
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Fatal(err)
-		}
+	p := istools.NewProcessor(func(b []byte) ([]byte, error) {
 		var is = new(finc.IntermediateSchema)
 		if err := json.Unmarshal(b, is); err != nil {
-			log.Fatal(err)
-		}
-		signature := holdings.Signature{
-			Date:   is.Date.Format("2006-01-02"),
-			Volume: is.Volume,
-			Issue:  is.Issue,
+			return nil, err
 		}
 
-		// validate record, if at least one license allows this item
-		var valid bool
-		var messages = container.NewStringSet()
-
-	LOOP:
-		for _, issn := range append(is.ISSN, is.EISSN...) {
-			licenses := entries.Licenses(issn)
-
-			if len(licenses) == 0 {
-				messages.Add(fmt.Sprintf("ISSN not in holdings"))
-			}
-
-			if len(licenses) == 0 && *permissiveMode {
-				messages.Add("PERMISSIVE_OK")
-				valid = true
-				break LOOP
+		if *explain != "" {
+			if !ids.Contains(is.RecordID) {
+				return nil, nil
 			}
-
-			for _, license := range licenses {
-				if err := license.Covers(signature); err != nil {
-					messages.Add(err.Error())
-				} else {
-					if err := license.TimeRestricted(is.Date); err != nil {
-						messages.Add(err.Error())
-					} else {
-						messages.Add("OK")
-						valid = true
-						break LOOP
-					}
-				}
+			// Label a copy, so the labels match those of a normal run.
+			labeledCopy := *is
+			labeledCopy.Labels = append([]string{}, is.Labels...)
+			e := explanation{ID: is.RecordID, Tree: tree.Explain(*is)}
+			e.Checks = applyLabels(&labeledCopy, tree, labeled)
+			e.Labels = labeledCopy.Labels
+			bs, err := json.Marshal(e)
+			if err != nil {
+				return nil, err
 			}
+			return append(bs, '\n'), nil
 		}
 
-		if len(is.ISSN) == 0 && len(is.EISSN) == 0 {
-			messages.Add("Record has no ISSN")
-		}
-
-		if len(is.ISSN) == 0 && len(is.EISSN) == 0 && *permissiveMode {
-			messages.Add("PERMISSIVE_OK")
-			valid = true
-		}
-
-		if valid {
-			is.Labels = []string{*label}
-		}
+		applyLabels(is, tree, labeled)
 
 		bs, err := json.Marshal(is)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
-		fmt.Println(string(bs))
+		return append(bs, '\n'), nil
+	})
+	p.NumWorkers = *numWorkers
+	p.BatchSize = *batchSize
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
 
-		// values := messages.Values()
-		// sort.Strings(values)
-		// fmt.Printf("%s\t%v\t%v\n", is.RecordID, valid, strings.Join(values, ", "))
+	if err := p.Run(r, w); err != nil {
+		log.Fatal(err)
 	}
 }
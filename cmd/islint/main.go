@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -10,43 +11,96 @@ import (
 	"math/rand"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/miku/istools"
+	"github.com/miku/span/container"
 	"github.com/miku/span/finc"
 )
 
 var (
-	// tests to run, could be made configurable later
-	tests   = istools.DefaultTests
-	verbose *bool
-	details *bool
-	start   = time.Now()
+	// selection of tests to run, configurable via -include and -exclude
+	selection istools.Selection
+	verbose   *bool
+	details   *bool
+	// detailsFormat is either tsv or jsonl
+	detailsFormat *string
+	examples      *int
+	start         = time.Now()
+
+	// filter mode passes clean records through and diverts rejected ones
+	filterMode      *bool
+	filterThreshold istools.Severity
+	rejects         io.Writer
+
+	// detector finds duplicates across records, if enabled
+	detector *istools.DuplicateDetector
 )
 
+// result is a raw and parsed record along with its issues.
+type result struct {
+	record []byte
+	is     finc.IntermediateSchema
+	issues []istools.Issue
+}
+
+// rejected is a record along with the issues that caused its rejection.
+type rejected struct {
+	Record json.RawMessage `json:"record"`
+	Issues []istools.Issue `json:"issues"`
+}
+
+// work is a numbered batch of raw records.
+type work struct {
+	seq   int
+	lines [][]byte
+}
+
+// batchResult holds the results of a batch, so the writer can restore input
+// order.
+type batchResult struct {
+	seq     int
+	results []result
+}
+
 // worker parses JSON and runs all tests on an intermediate schema record.
-func worker(queue chan [][]byte, out chan []istools.Issue, wg *sync.WaitGroup) {
+func worker(queue chan work, out chan batchResult, wg *sync.WaitGroup) {
 	defer wg.Done()
 	for batch := range queue {
-		for _, b := range batch {
+		br := batchResult{seq: batch.seq}
+		for _, b := range batch.lines {
 			var is finc.IntermediateSchema
 			if err := json.Unmarshal(b, &is); err != nil {
 				log.Fatal(err)
 			}
 			var issues []istools.Issue
-			for _, t := range tests {
-				err := t.TestRecord(is)
-				if err != nil {
-					if _, ok := err.(istools.Issue); !ok {
-						log.Fatalf("invalid error type: %T", err)
+			for _, t := range selection.Tests {
+				if t.Tester == nil {
+					continue
+				}
+				var found []istools.Issue
+				switch err := t.Tester.TestRecord(is).(type) {
+				case nil:
+				case istools.Issue:
+					found = append(found, err)
+				case istools.MultiIssue:
+					found = err
+				default:
+					log.Fatalf("invalid error type: %T", err)
+				}
+				for _, issue := range found {
+					if selection.Allows(issue.Kind) {
+						issues = append(issues, issue)
 					}
-					issues = append(issues, err.(istools.Issue))
 				}
 			}
-			out <- issues
+			br.results = append(br.results, result{record: b, is: is, issues: issues})
 		}
+		out <- br
 	}
 }
 
@@ -56,6 +110,112 @@ type Stats struct {
 	IssueDistribution map[istools.Kind]int `json:"issues"`
 	// IssuesPerRecord count the number of issues per record.
 	IssuesPerRecord map[int]int `json:"frequency"`
+	// IssuesPerSeverity counts the number of issues per severity.
+	IssuesPerSeverity map[istools.Severity]int `json:"severity"`
+	// RecordsPerSeverity counts records by the severity of their worst issue.
+	RecordsPerSeverity map[istools.Severity]int `json:"damaged_by_severity"`
+	// BySource breaks down issues by SourceID.
+	BySource map[string]*GroupStats `json:"sources"`
+	// ByCollection breaks down issues by MegaCollection.
+	ByCollection map[string]*GroupStats `json:"collections"`
+	// Examples keeps a sample of issues per kind.
+	Examples map[istools.Kind]*Reservoir `json:"examples"`
+}
+
+// Example is a short form of an issue.
+type Example struct {
+	RecordID string `json:"id"`
+	Message  string `json:"message,omitempty"`
+}
+
+// Reservoir keeps a uniform random sample of fixed size from a stream.
+type Reservoir struct {
+	Size  int
+	seen  int
+	Items []Example
+}
+
+// Add offers an example to the reservoir.
+func (r *Reservoir) Add(e Example) {
+	r.seen++
+	if len(r.Items) < r.Size {
+		r.Items = append(r.Items, e)
+		return
+	}
+	if j := rand.Intn(r.seen); j < r.Size {
+		r.Items[j] = e
+	}
+}
+
+// addExample samples an issue, if examples are enabled.
+func (s Stats) addExample(issue istools.Issue) {
+	if *examples == 0 {
+		return
+	}
+	r, ok := s.Examples[issue.Kind]
+	if !ok {
+		r = &Reservoir{Size: *examples}
+		s.Examples[issue.Kind] = r
+	}
+	r.Add(Example{RecordID: issue.Record.RecordID, Message: issue.Message})
+}
+
+// GroupStats keeps stats on the records of a single source or collection.
+type GroupStats struct {
+	Total             int
+	Damaged           int
+	IssueDistribution map[istools.Kind]int
+}
+
+// group returns the stats for a given key, creating them, if necessary.
+func group(groups map[string]*GroupStats, key string) *GroupStats {
+	g, ok := groups[key]
+	if !ok {
+		g = &GroupStats{IssueDistribution: make(map[istools.Kind]int)}
+		groups[key] = g
+	}
+	return g
+}
+
+// Add counts a record along with its issues.
+func (g *GroupStats) Add(issues []istools.Issue) {
+	g.Total++
+	if len(issues) > 0 {
+		g.Damaged++
+	}
+	for _, issue := range issues {
+		g.IssueDistribution[issue.Kind]++
+	}
+}
+
+// MarshalJSON adds the damaged ratio.
+func (g *GroupStats) MarshalJSON() ([]byte, error) {
+	dist := make(map[string]int)
+	for k, v := range g.IssueDistribution {
+		dist[k.String()] = v
+	}
+	var percent float64
+	if g.Total > 0 {
+		percent = (100 / float64(g.Total)) * float64(g.Damaged)
+	}
+	return json.Marshal(map[string]interface{}{
+		"dist":    dist,
+		"total":   g.Total,
+		"damaged": g.Damaged,
+		"percent": fmt.Sprintf("%0.3f", percent),
+	})
+}
+
+// Worst returns the highest severity seen and false, if no issue was seen.
+func (s Stats) Worst() (istools.Severity, bool) {
+	var worst istools.Severity
+	var seen bool
+	for severity, count := range s.IssuesPerSeverity {
+		if count > 0 && (!seen || severity > worst) {
+			worst, seen = severity, true
+		}
+	}
+	return worst, seen
 }
 
 // MarshalJSON calculates a few extra metrics on the fly.
@@ -79,33 +239,149 @@ func (s Stats) MarshalJSON() ([]byte, error) {
 
 	percent := (100 / float64(total)) * float64(damaged)
 
+	severity := make(map[string]int)
+	for k, v := range s.IssuesPerSeverity {
+		severity[k.String()] = v
+	}
+	damagedBySeverity := make(map[string]int)
+	for k, v := range s.RecordsPerSeverity {
+		damagedBySeverity[k.String()] = v
+	}
+
+	samples := make(map[string][]Example)
+	for k, v := range s.Examples {
+		samples[k.String()] = v.Items
+	}
+
 	return json.Marshal(map[string]interface{}{
-		"dist":     dist,
-		"errcount": errcount,
-		"total":    total,
-		"damaged":  damaged,
-		"percent":  fmt.Sprintf("%0.3f", percent),
-		"start":    start,
-		"elapsed":  time.Since(start).Seconds(),
-		"version":  fmt.Sprintf("%s/%d", istools.Version, len(tests)),
+		"dist":                dist,
+		"errcount":            errcount,
+		"total":               total,
+		"damaged":             damaged,
+		"severity":            severity,
+		"damaged_by_severity": damagedBySeverity,
+		"percent":             fmt.Sprintf("%0.3f", percent),
+		"start":               start,
+		"elapsed":             time.Since(start).Seconds(),
+		"version":             fmt.Sprintf("%s/%s", istools.Version, strings.Join(selection.Names(), ",")),
+		"sources":             s.BySource,
+		"collections":         s.ByCollection,
+		"examples":            samples,
 	})
 }
 
+// passOrReject writes a record to w, if none of its issues reaches the filter
+// threshold, otherwise the annotated record is written to rejects.
+func passOrReject(w io.Writer, r result) error {
+	var reject bool
+	for _, issue := range r.issues {
+		if issue.Kind.Severity() >= filterThreshold {
+			reject = true
+			break
+		}
+	}
+	if !reject {
+		if _, err := w.Write(r.record); err != nil {
+			return err
+		}
+		if !bytes.HasSuffix(r.record, []byte("\n")) {
+			_, err := io.WriteString(w, "\n")
+			return err
+		}
+		return nil
+	}
+	v := rejected{Record: json.RawMessage(bytes.TrimSpace(r.record)), Issues: r.issues}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(rejects, string(b))
+	return err
+}
+
+// writeDetail writes a single issue to stdout in the selected details format.
+func writeDetail(issue istools.Issue) {
+	switch *detailsFormat {
+	case "jsonl":
+		b, err := json.Marshal(issue)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+	default:
+		fmt.Println(issue.TSV())
+	}
+}
+
 // writer will dump a list of issues as JSON to stdout. Intermediate results are dumped
-func writer(batches chan []istools.Issue, done chan bool) {
+// periodically with -verbose. Batches are handled in input order, so records
+// pass through -filter unchanged in order.
+func writer(batches chan batchResult, done chan Stats) {
 	stats := Stats{
-		IssueDistribution: make(map[istools.Kind]int),
-		IssuesPerRecord:   make(map[int]int),
+		IssueDistribution:  make(map[istools.Kind]int),
+		IssuesPerRecord:    make(map[int]int),
+		IssuesPerSeverity:  make(map[istools.Severity]int),
+		RecordsPerSeverity: make(map[istools.Severity]int),
+		BySource:           make(map[string]*GroupStats),
+		ByCollection:       make(map[string]*GroupStats),
+		Examples:           make(map[istools.Kind]*Reservoir),
 	}
+	stdout := bufio.NewWriter(os.Stdout)
+	defer stdout.Flush()
+
 	var i int
-	for issues := range batches {
+	results := make(chan result)
+	go func() {
+		var next int
+		pending := make(map[int][]result)
+		for br := range batches {
+			pending[br.seq] = br.results
+			for {
+				rs, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				for _, r := range rs {
+					results <- r
+				}
+			}
+		}
+		close(results)
+	}()
+
+	for r := range results {
+		if detector != nil {
+			if err := detector.Add(r.is); err != nil {
+				log.Fatal(err)
+			}
+		}
+		issues := r.issues
+		if *filterMode {
+			if err := passOrReject(stdout, r); err != nil {
+				log.Fatal(err)
+			}
+		}
 		stats.IssuesPerRecord[len(issues)]++
+		group(stats.BySource, r.is.SourceID).Add(issues)
+		group(stats.ByCollection, r.is.MegaCollection).Add(issues)
+		var worst istools.Severity
 		for _, issue := range issues {
+			severity := issue.Kind.Severity()
+			if severity > worst {
+				worst = severity
+			}
 			stats.IssueDistribution[issue.Kind]++
+			stats.IssuesPerSeverity[severity]++
+			stats.addExample(issue)
 			if *details {
-				fmt.Println(issue.TSV())
+				writeDetail(issue)
 			}
 		}
+		if len(issues) > 0 {
+			stats.RecordsPerSeverity[worst]++
+		}
 		i++
 		if i%1000000 == 0 {
 			b, err := json.Marshal(stats)
@@ -117,25 +393,173 @@ func writer(batches chan []istools.Issue, done chan bool) {
 			}
 		}
 	}
+	if detector != nil {
+		// Duplicates are only known at the end, they are counted as issues,
+		// but do not change the per record figures. They are left out of the
+		// per source and per collection stats, which are per record figures
+		// as well: a duplicate would count as an issue, but not as damaged.
+		issues, err := detector.Issues()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, issue := range issues {
+			if !selection.Allows(issue.Kind) {
+				continue
+			}
+			stats.IssueDistribution[issue.Kind]++
+			stats.IssuesPerSeverity[issue.Kind.Severity()]++
+			stats.addExample(issue)
+			if *details {
+				writeDetail(issue)
+			}
+		}
+	}
+
 	b, err := json.Marshal(stats)
 	if err != nil {
 		log.Fatal(err)
 	}
-	if *details {
+	if *details || *filterMode {
 		fmt.Fprintln(os.Stderr, string(b))
 	} else {
 		fmt.Println(string(b))
 	}
 
-	done <- true
+	if err := stdout.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	done <- stats
+}
+
+// listRegistered writes all registered tests. The default is one line per
+// kind, with kind, test, description and refs separated by tab.
+func listRegistered(w io.Writer, asJSON bool) error {
+	tests := istools.Registered()
+	if asJSON {
+		type entry struct {
+			Name        string   `json:"name"`
+			Kinds       []string `json:"kinds"`
+			Description string   `json:"description"`
+			Refs        []string `json:"refs,omitempty"`
+		}
+		var entries []entry
+		for _, t := range tests {
+			e := entry{Name: t.Name, Description: t.Description, Refs: t.Refs}
+			for _, k := range t.Kinds {
+				e.Kinds = append(e.Kinds, k.String())
+			}
+			entries = append(entries, e)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "    ")
+		return enc.Encode(entries)
+	}
+	var lines []string
+	for _, t := range tests {
+		for _, k := range t.Kinds {
+			lines = append(lines, fmt.Sprintf("%s\t%s\t%s\t%s", k, t.Name, t.Description, strings.Join(t.Refs, ", ")))
+		}
+	}
+	sort.Strings(lines)
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// demuxWriter routes lines prefixed with 'C' to changelog and lines prefixed
+// with 'R' to records, so both come out in input order.
+type demuxWriter struct {
+	records   io.Writer
+	changelog io.Writer
+	buf       []byte
+}
+
+// Write routes all complete lines and keeps the rest for the next call.
+func (w *demuxWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := w.buf[:i+1]
+		target := w.records
+		if line[0] == 'C' {
+			target = w.changelog
+		}
+		if _, err := target.Write(line[1:]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// fixRecords applies the default fixers to all records, writes the repaired
+// records to w and a TSV line per change to changelog. Changes are written in
+// the order of the records.
+func fixRecords(r io.Reader, w io.Writer, changelog io.Writer) error {
+	p := istools.NewProcessor(func(b []byte) ([]byte, error) {
+		var is finc.IntermediateSchema
+		if err := json.Unmarshal(b, &is); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		for _, f := range istools.DefaultFixers {
+			for _, c := range f.FixRecord(&is) {
+				fmt.Fprintf(&buf, "C%s\n", c.TSV())
+			}
+		}
+		bs, err := json.Marshal(is)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte('R')
+		buf.Write(bs)
+		buf.WriteByte('\n')
+		return buf.Bytes(), nil
+	})
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+	cw := bufio.NewWriter(changelog)
+	defer cw.Flush()
+	return p.Run(r, &demuxWriter{records: bw, changelog: cw})
+}
+
+// splitNames splits a comma separated list of names.
+func splitNames(s string) []string {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
 }
 
 func main() {
-	details = flag.Bool("details", false, "show error details for every record as TSV")
+	details = flag.Bool("details", false, "show error details for every issue, see -details-format")
+	detailsFormat = flag.String("details-format", "tsv", "format of -details, tsv or jsonl")
 	verbose = flag.Bool("verbose", false, "show progress")
+	examples = flag.Int("examples", 5, "number of example issues per kind to include in the report")
 	version := flag.Bool("v", false, "show version and exit")
-	listTests := flag.Bool("ls", false, "list tests")
-	sample := flag.Float64("sample", 1.0, "ratio of records to test")
+	listTests := flag.Bool("ls", false, "list issue kinds and their tests")
+	listJSON := flag.Bool("json", false, "with -ls, list tests as JSON")
+	sample := flag.Float64("sample", 1.0, "ratio of records to test, cannot be used with -filter")
+	filterMode = flag.Bool("filter", false, "pass records without issues to stdout, write stats to stderr")
+	filterSeverity := flag.String("filter-severity", "info", "with -filter, reject records with issues of this severity or higher")
+	rejectsFile := flag.String("rejects", "", "with -filter, write rejected records along with their issues to this file, required")
+	fix := flag.Bool("fix", false, "repair records, write them to stdout and log all changes as TSV, runs no tests")
+	fixLog := flag.String("fixlog", "", "with -fix, write changes to this file instead of stderr")
+	failOn := flag.String("fail-on", "", "exit with non-zero status, if an issue of this severity or higher is found: info, warning, error")
+	urlSchemes := flag.String("url-schemes", "http,https", "comma separated list of allowed URL schemes")
+	blockedHosts := flag.String("url-blocked-hosts", "localhost,127.0.0.1", "comma separated list of hosts not allowed in URLs")
+	dupes := flag.Bool("dupes", false, "report duplicate record ids and DOI across records, uses a temporary file; duplicates are only known at the end, so they never reject records with -filter")
+	dupesExpected := flag.Int("dupes-expected", 10000000, "with -dupes, expected number of records, to size the bloom filter")
+	include := flag.String("include", "", "comma separated test or kind names to run, default: all")
+	exclude := flag.String("exclude", "", "comma separated test or kind names to skip")
 
 	flag.Parse()
 
@@ -145,30 +569,80 @@ func main() {
 	}
 
 	if *listTests {
-		fmt.Println(`CurrencyInTitle
-EndPageBeforeStartPage
-EtAlAuthorName
-ExcessivePunctuation
-HTMLEntityInAuthorName
-InvalidCollection
-InvalidEndPage
-InvalidStartPage
-InvalidURL
-KeyTooLong
-NAInAuthorName
-NonCanonicalISSN
-NoPublisher
-NoURL
-PublicationDateTooEarly
-PublicationDateTooLate
-RepeatedSlash
-RepeatedSubtitle
-ShortAuthorName
-SuspiciousPageCount
-WhitespaceAuthor`)
+		if err := listRegistered(os.Stdout, *listJSON); err != nil {
+			log.Fatal(err)
+		}
 		os.Exit(0)
 	}
 
+	if *fix {
+		// Fixing runs no tests, so flags about tests and their results would
+		// silently have no effect.
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "fix", "fixlog":
+			default:
+				log.Fatalf("-%s cannot be used with -fix", f.Name)
+			}
+		})
+	} else if *fixLog != "" {
+		log.Fatal("-fixlog requires -fix")
+	}
+
+	if *detailsFormat != "tsv" && *detailsFormat != "jsonl" {
+		log.Fatalf("invalid details format: %s", *detailsFormat)
+	}
+
+	var err error
+	if selection, err = istools.Select(istools.Registered(), splitNames(*include), splitNames(*exclude)); err != nil {
+		log.Fatal(err)
+	}
+
+	istools.AllowedURLSchemes = container.NewStringSet(splitNames(strings.ToLower(*urlSchemes))...)
+	istools.BlockedURLHosts = container.NewStringSet(splitNames(strings.ToLower(*blockedHosts))...)
+
+	var threshold istools.Severity
+	if *failOn != "" {
+		if threshold, err = istools.ParseSeverity(*failOn); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var closeRejects = func() error { return nil }
+
+	if *filterMode {
+		if *details {
+			log.Fatal("-details cannot be used with -filter")
+		}
+		if *sample < 1 {
+			log.Fatal("-sample cannot be used with -filter, skipped records would be lost")
+		}
+		if *rejectsFile == "" {
+			log.Fatal("-filter requires -rejects, rejected records would be lost")
+		}
+		if filterThreshold, err = istools.ParseSeverity(*filterSeverity); err != nil {
+			log.Fatal(err)
+		}
+		file, err := os.Create(*rejectsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bw := bufio.NewWriter(file)
+		rejects = bw
+		closeRejects = func() error {
+			if err := bw.Flush(); err != nil {
+				return err
+			}
+			return file.Close()
+		}
+	}
+
+	if *dupes {
+		if detector, err = istools.NewDuplicateDetector(*dupesExpected); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	var r io.Reader
 
 	if flag.NArg() == 0 {
@@ -181,15 +655,31 @@ WhitespaceAuthor`)
 		r = file
 	}
 
+	if *fix {
+		var changelog io.Writer = os.Stderr
+		if *fixLog != "" {
+			file, err := os.Create(*fixLog)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer file.Close()
+			changelog = file
+		}
+		if err := fixRecords(r, os.Stdout, changelog); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	reader := bufio.NewReader(r)
 
-	var i int
+	var i, seq int
 	var batch [][]byte
 	var size = 40000
 
-	queue := make(chan [][]byte)
-	out := make(chan []istools.Issue)
-	done := make(chan bool)
+	queue := make(chan work)
+	out := make(chan batchResult)
+	done := make(chan Stats)
 
 	var wg sync.WaitGroup
 
@@ -202,33 +692,44 @@ WhitespaceAuthor`)
 
 	for {
 		b, err := reader.ReadBytes('\n')
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
+		if err != nil && err != io.EOF {
 			log.Fatal(err)
 		}
-		if rand.Float64() > *sample {
-			continue
+		// The last record may lack a trailing newline.
+		if len(b) > 0 && rand.Float64() <= *sample {
+			if i == size {
+				ba := make([][]byte, len(batch))
+				copy(ba, batch)
+				queue <- work{seq: seq, lines: ba}
+				batch = batch[:0]
+				i = 0
+				seq++
+			}
+			batch = append(batch, b)
+			i++
 		}
-		if i == size {
-			ba := make([][]byte, len(batch))
-			copy(ba, batch)
-			queue <- ba
-			batch = batch[:0]
-			i = 0
+		if err == io.EOF {
+			break
 		}
-		batch = append(batch, b)
-		i++
 	}
 
 	ba := make([][]byte, len(batch))
 	copy(ba, batch)
-	queue <- ba
+	queue <- work{seq: seq, lines: ba}
 	batch = batch[:0]
 
 	close(queue)
 	wg.Wait()
 	close(out)
-	<-done
+	stats := <-done
+
+	if err := closeRejects(); err != nil {
+		log.Fatal(err)
+	}
+
+	if *failOn != "" {
+		if worst, ok := stats.Worst(); ok && worst >= threshold {
+			os.Exit(1)
+		}
+	}
 }
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miku/istools"
+)
+
+func TestStatsWorst(t *testing.T) {
+	var cases = []struct {
+		about    string
+		counts   map[istools.Severity]int
+		severity istools.Severity
+		seen     bool
+	}{
+		{"empty", map[istools.Severity]int{}, istools.SeverityInfo, false},
+		{"nil", nil, istools.SeverityInfo, false},
+		{"zero counts", map[istools.Severity]int{istools.SeverityError: 0}, istools.SeverityInfo, false},
+		{"info only", map[istools.Severity]int{istools.SeverityInfo: 3}, istools.SeverityInfo, true},
+		{"mixed", map[istools.Severity]int{istools.SeverityInfo: 3, istools.SeverityError: 1, istools.SeverityWarning: 2}, istools.SeverityError, true},
+		{"mixed, no errors", map[istools.Severity]int{istools.SeverityInfo: 3, istools.SeverityError: 0, istools.SeverityWarning: 2}, istools.SeverityWarning, true},
+	}
+	for _, c := range cases {
+		severity, seen := Stats{IssuesPerSeverity: c.counts}.Worst()
+		if seen != c.seen {
+			t.Errorf("%s: got %v, want %v", c.about, seen, c.seen)
+		}
+		if severity != c.severity {
+			t.Errorf("%s: got %v, want %v", c.about, severity, c.severity)
+		}
+	}
+}
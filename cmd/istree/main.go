@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/miku/istools"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: istree [OPTIONS] check FILE\n\n")
+	fmt.Fprintf(os.Stderr, "Check a JSON filter tree, as used by islabel -conf. Reports all problems\n")
+	fmt.Fprintf(os.Stderr, "with their location in the document, reads all referenced holdings and list\n")
+	fmt.Fprintf(os.Stderr, "files and compiles all regular expressions. Exits with 1, if there are problems.\n\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	version := flag.Bool("version", false, "show version")
+	asJSON := flag.Bool("json", false, "report problems as JSON lines")
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if *version {
+		fmt.Println(istools.Version)
+		os.Exit(0)
+	}
+
+	if flag.NArg() != 2 || flag.Arg(0) != "check" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	errs, err := istools.ValidateFile(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, e := range errs {
+		if *asJSON {
+			if err := enc.Encode(map[string]string{"path": e.Path, "message": e.Err.Error()}); err != nil {
+				log.Fatal(err)
+			}
+		} else {
+			fmt.Println(e)
+		}
+	}
+	if len(errs) > 0 {
+		log.Printf("%s: %d problem(s)", flag.Arg(1), len(errs))
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,240 @@
+// Package coverage checks intermediate schema records against holdings files.
+package coverage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/miku/holdings"
+	"github.com/miku/holdings/google"
+	"github.com/miku/holdings/kbart"
+	"github.com/miku/holdings/ovid"
+	"github.com/miku/span/container"
+	"github.com/miku/span/finc"
+)
+
+// NewReader returns a holdings reader for a given format: kbart, ovid or
+// google.
+func NewReader(r io.Reader, format string) (holdings.File, error) {
+	switch format {
+	case "kbart":
+		return kbart.NewReader(r), nil
+	case "ovid":
+		return ovid.NewReader(r), nil
+	case "google":
+		return google.NewReader(r), nil
+	default:
+		return nil, fmt.Errorf("invalid holding file format: %s", format)
+	}
+}
+
+// DetectFormat guesses the format of a holdings file from its first bytes.
+// Google holdings are XML with an institutional_holdings root, other XML is
+// taken to be ovid, everything else kbart.
+func DetectFormat(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, 4096)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	head := bytes.TrimLeft(bytes.TrimPrefix(buf[:n], []byte("\xef\xbb\xbf")), " \t\r\n")
+	switch {
+	case !bytes.HasPrefix(head, []byte("<")):
+		return "kbart", nil
+	case bytes.Contains(head, []byte("institutional_holdings")):
+		return "google", nil
+	default:
+		return "ovid", nil
+	}
+}
+
+// ReadFile reads all entries from a holdings file. If the file contains
+// unparsable lines, the entries read so far are returned together with a
+// holdings.ParseError.
+func ReadFile(filename, format string) (holdings.Entries, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	hr, err := NewReader(f, format)
+	if err != nil {
+		return nil, err
+	}
+	return hr.ReadAll()
+}
+
+//go:generate stringer -type=Code
+
+// Code identifies the kind of a reason.
+type Code int
+
+const (
+	// Covered means a license allows the record.
+	Covered Code = iota
+	// NoISSN means the record carries neither ISSN nor EISSN.
+	NoISSN
+	// ISSNUnknown means there are no licenses for an ISSN.
+	ISSNUnknown
+	// BeforeCoverageStart means the record predates the license.
+	BeforeCoverageStart
+	// AfterCoverageEnd means the record is newer than the license.
+	AfterCoverageEnd
+	// NotCovered means the license does not cover the record for other
+	// reasons, e.g. missing values.
+	NotCovered
+	// Embargo means the record falls within a moving wall.
+	Embargo
+	// PermissiveOverride means the record could not be checked, but is
+	// allowed nonetheless.
+	PermissiveOverride
+)
+
+// MarshalJSON encodes the code by name.
+func (c Code) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+// Reason explains a single step of a coverage decision.
+type Reason struct {
+	Code Code `json:"code"`
+	// ISSN, that was looked up, if any.
+	ISSN string `json:"issn,omitempty"`
+	// License, that produced this reason, if any.
+	License holdings.License `json:"license,omitempty"`
+	// Err is the error returned by the license, if any.
+	Err error `json:"-"`
+}
+
+// String returns a short message, compatible with earlier versions of iscov.
+func (r Reason) String() string {
+	switch r.Code {
+	case Covered:
+		return "OK"
+	case NoISSN:
+		return "Record has no ISSN"
+	case ISSNUnknown:
+		return "ISSN not in holdings"
+	case PermissiveOverride:
+		return "PERMISSIVE_OK"
+	}
+	if r.Err != nil {
+		return r.Err.Error()
+	}
+	return r.Code.String()
+}
+
+// MarshalJSON adds the message to the JSON representation.
+func (r Reason) MarshalJSON() ([]byte, error) {
+	type alias Reason
+	return json.Marshal(struct {
+		alias
+		Message string `json:"message"`
+	}{alias(r), r.String()})
+}
+
+// coversCode maps an error returned by license.Covers to a code.
+func coversCode(err error) Code {
+	switch err {
+	case holdings.ErrBeforeCoverageInterval:
+		return BeforeCoverageStart
+	case holdings.ErrAfterCoverageInterval:
+		return AfterCoverageEnd
+	default:
+		return NotCovered
+	}
+}
+
+// Verdict is the result of a coverage check.
+type Verdict struct {
+	// Valid is true, if at least one license allows the record.
+	Valid bool `json:"valid"`
+	// Reasons lists the steps, that lead to the decision, in order.
+	Reasons []Reason `json:"reasons"`
+	// ISSN under which the allowing license was found, if any.
+	ISSN string `json:"issn,omitempty"`
+	// License, that allowed the record, if any.
+	License holdings.License `json:"license,omitempty"`
+}
+
+// Messages returns the sorted, unique messages of all reasons.
+func (v Verdict) Messages() []string {
+	set := container.NewStringSet()
+	for _, r := range v.Reasons {
+		set.Add(r.String())
+	}
+	values := set.Values()
+	sort.Strings(values)
+	return values
+}
+
+// Checker validates records against holdings entries.
+type Checker struct {
+	Entries holdings.Entries
+	// Permissive allows records, that cannot be checked, because they carry
+	// no ISSN or their ISSN is not in the holdings.
+	Permissive bool
+}
+
+// Check validates a record. A record is valid, if at least one license allows
+// this item.
+func (c Checker) Check(is finc.IntermediateSchema) Verdict {
+	var verdict Verdict
+
+	signature := holdings.Signature{
+		Date:   is.Date.Format("2006-01-02"),
+		Volume: is.Volume,
+		Issue:  is.Issue,
+	}
+
+LOOP:
+	for _, issn := range append(is.ISSN, is.EISSN...) {
+		licenses := c.Entries.Licenses(issn)
+
+		if len(licenses) == 0 {
+			verdict.Reasons = append(verdict.Reasons, Reason{Code: ISSNUnknown, ISSN: issn})
+			if c.Permissive {
+				verdict.Reasons = append(verdict.Reasons, Reason{Code: PermissiveOverride, ISSN: issn})
+				verdict.Valid = true
+				break LOOP
+			}
+		}
+
+		for _, license := range licenses {
+			if err := license.Covers(signature); err != nil {
+				verdict.Reasons = append(verdict.Reasons, Reason{
+					Code: coversCode(err), ISSN: issn, License: license, Err: err})
+				continue
+			}
+			if err := license.TimeRestricted(is.Date); err != nil {
+				verdict.Reasons = append(verdict.Reasons, Reason{
+					Code: Embargo, ISSN: issn, License: license, Err: err})
+				continue
+			}
+			verdict.Reasons = append(verdict.Reasons, Reason{Code: Covered, ISSN: issn, License: license})
+			verdict.Valid = true
+			verdict.ISSN = issn
+			verdict.License = license
+			break LOOP
+		}
+	}
+
+	if len(is.ISSN) == 0 && len(is.EISSN) == 0 {
+		verdict.Reasons = append(verdict.Reasons, Reason{Code: NoISSN})
+		if c.Permissive {
+			verdict.Reasons = append(verdict.Reasons, Reason{Code: PermissiveOverride})
+			verdict.Valid = true
+		}
+	}
+
+	return verdict
+}
@@ -0,0 +1,197 @@
+package coverage
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miku/holdings"
+	"github.com/miku/span/finc"
+)
+
+// license is a test license, that covers everything up to a given date.
+type license struct {
+	until      string
+	restricted bool
+}
+
+func (l license) Covers(s holdings.Signature) error {
+	if s.Date > l.until {
+		return holdings.ErrAfterCoverageInterval
+	}
+	return nil
+}
+
+func (l license) TimeRestricted(t time.Time) error {
+	if l.restricted {
+		return errors.New("moving wall")
+	}
+	return nil
+}
+
+func TestCheck(t *testing.T) {
+	entries := holdings.Entries{
+		"1234-5678": []holdings.License{license{until: "2000-01-01"}},
+		"2222-2222": []holdings.License{license{until: "2010-01-01", restricted: true}},
+	}
+	date := time.Date(2005, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var cases = []struct {
+		about      string
+		is         finc.IntermediateSchema
+		permissive bool
+		valid      bool
+		messages   []string
+		codes      []Code
+	}{
+		{
+			about:    "no ISSN",
+			is:       finc.IntermediateSchema{},
+			messages: []string{"Record has no ISSN"},
+			codes:    []Code{NoISSN},
+		},
+		{
+			about:      "no ISSN, permissive",
+			is:         finc.IntermediateSchema{},
+			permissive: true,
+			valid:      true,
+			messages:   []string{"PERMISSIVE_OK", "Record has no ISSN"},
+			codes:      []Code{NoISSN, PermissiveOverride},
+		},
+		{
+			about:    "unknown ISSN",
+			is:       finc.IntermediateSchema{ISSN: []string{"0000-0000"}, Date: date},
+			messages: []string{"ISSN not in holdings"},
+			codes:    []Code{ISSNUnknown},
+		},
+		{
+			about:    "after coverage",
+			is:       finc.IntermediateSchema{ISSN: []string{"1234-5678"}, Date: date},
+			messages: []string{"after coverage interval"},
+			codes:    []Code{AfterCoverageEnd},
+		},
+		{
+			about:    "moving wall",
+			is:       finc.IntermediateSchema{EISSN: []string{"2222-2222"}, Date: date},
+			messages: []string{"moving wall"},
+			codes:    []Code{Embargo},
+		},
+		{
+			about:    "covered",
+			is:       finc.IntermediateSchema{ISSN: []string{"0000-0000", "1234-5678"}, Date: date.AddDate(-10, 0, 0)},
+			valid:    true,
+			messages: []string{"ISSN not in holdings", "OK"},
+			codes:    []Code{ISSNUnknown, Covered},
+		},
+	}
+
+	for _, c := range cases {
+		checker := Checker{Entries: entries, Permissive: c.permissive}
+		verdict := checker.Check(c.is)
+		if verdict.Valid != c.valid {
+			t.Errorf("%s: got %v, want %v", c.about, verdict.Valid, c.valid)
+		}
+		if messages := verdict.Messages(); !reflect.DeepEqual(messages, c.messages) {
+			t.Errorf("%s: got %v, want %v", c.about, messages, c.messages)
+		}
+		var codes []Code
+		for _, r := range verdict.Reasons {
+			codes = append(codes, r.Code)
+		}
+		if !reflect.DeepEqual(codes, c.codes) {
+			t.Errorf("%s: got %v, want %v", c.about, codes, c.codes)
+		}
+		if verdict.Valid && !c.permissive && verdict.License == nil {
+			t.Errorf("%s: got no license, want license", c.about)
+		}
+	}
+}
+
+func TestCoversCode(t *testing.T) {
+	var cases = []struct {
+		err  error
+		code Code
+	}{
+		{holdings.ErrBeforeCoverageInterval, BeforeCoverageStart},
+		{holdings.ErrAfterCoverageInterval, AfterCoverageEnd},
+		{holdings.ErrMissingValues, NotCovered},
+		{errors.New("before coverage interval"), NotCovered},
+	}
+	for _, c := range cases {
+		if code := coversCode(c.err); code != c.code {
+			t.Errorf("%v: got %v, want %v", c.err, code, c.code)
+		}
+	}
+}
+
+func TestCheckKBART(t *testing.T) {
+	f, err := ioutil.TempFile("", "istools-kbart-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	io.WriteString(f, "publication_title\tprint_identifier\tonline_identifier\t"+
+		"date_first_issue_online\tnum_first_vol_online\tnum_first_issue_online\t"+
+		"date_last_issue_online\tnum_last_vol_online\tnum_last_issue_online\n")
+	io.WriteString(f, "Journal\t1234-5678\t\t2000-01-01\t\t\t2010-12-31\t\t\n")
+	f.Close()
+
+	entries, err := ReadFile(f.Name(), "kbart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases = []struct {
+		year int
+		code Code
+	}{
+		{1990, BeforeCoverageStart},
+		{2005, Covered},
+		{2015, AfterCoverageEnd},
+	}
+	for _, c := range cases {
+		is := finc.IntermediateSchema{
+			ISSN: []string{"1234-5678"},
+			Date: time.Date(c.year, 6, 1, 0, 0, 0, 0, time.UTC),
+		}
+		verdict := Checker{Entries: entries}.Check(is)
+		if len(verdict.Reasons) != 1 {
+			t.Errorf("%d: got %v, want one reason", c.year, verdict.Reasons)
+			continue
+		}
+		if code := verdict.Reasons[0].Code; code != c.code {
+			t.Errorf("%d: got %v, want %v", c.year, code, c.code)
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	var cases = []struct {
+		content string
+		format  string
+	}{
+		{"publication_title\tprint_identifier\tonline_identifier\n", "kbart"},
+		{"\n  <?xml version=\"1.0\"?>\n<institutional_holdings><item/></institutional_holdings>", "google"},
+		{"<?xml version=\"1.0\"?>\n<holding><item/></holding>", "ovid"},
+		{"", "kbart"},
+	}
+	for _, c := range cases {
+		f, err := ioutil.TempFile("", "istools-holdings-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(f, c.content)
+		f.Close()
+		format, err := DetectFormat(f.Name())
+		os.Remove(f.Name())
+		if err != nil {
+			t.Errorf("got %v, want nil", err)
+		}
+		if format != c.format {
+			t.Errorf("got %s, want %s", format, c.format)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package istools
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/miku/span/finc"
+)
+
+// bloomFilter is a plain bloom filter, using double hashing to derive k hash
+// functions from two FNV hashes.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    int
+}
+
+// newBloomFilter sizes a filter for n items with a false positive rate p.
+func newBloomFilter(n int, p float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := int(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+	return &bloomFilter{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// add adds a key and returns true, if the key was (probably) added before.
+func (f *bloomFilter) add(key string) bool {
+	h1, h2 := fnv.New64a(), fnv.New64()
+	io.WriteString(h1, key)
+	io.WriteString(h2, key)
+	a, b := h1.Sum64(), h2.Sum64()|1
+	seen := true
+	for i := 0; i < f.k; i++ {
+		j := (a + uint64(i)*b) % f.m
+		if f.bits[j/64]&(1<<(j%64)) == 0 {
+			seen = false
+			f.bits[j/64] |= 1 << (j % 64)
+		}
+	}
+	return seen
+}
+
+// DuplicateDetector finds duplicate record ids and DOI, that are shared by
+// different records of the same source. Memory usage is bounded by a bloom
+// filter and the number of candidates: every key is spilled to a temporary
+// file and keys, that the filter reports as seen before, are confirmed by
+// exact counting over that file in Issues.
+type DuplicateDetector struct {
+	filter     *bloomFilter
+	candidates map[string]bool
+	spill      *os.File
+	w          *bufio.Writer
+}
+
+// NewDuplicateDetector creates a detector, sized for the expected number of
+// records.
+func NewDuplicateDetector(expected int) (*DuplicateDetector, error) {
+	f, err := ioutil.TempFile("", "istools-dupes-")
+	if err != nil {
+		return nil, err
+	}
+	return &DuplicateDetector{
+		// Two keys per record, record id and DOI.
+		filter:     newBloomFilter(2*expected, 0.01),
+		candidates: make(map[string]bool),
+		spill:      f,
+		w:          bufio.NewWriter(f),
+	}, nil
+}
+
+// spillReplacer keeps keys on a single line.
+var spillReplacer = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+// add records a single key along with the record it was found in.
+func (d *DuplicateDetector) add(key string, is finc.IntermediateSchema) error {
+	key = spillReplacer.Replace(key)
+	if d.filter.add(key) {
+		d.candidates[key] = true
+	}
+	_, err := fmt.Fprintf(d.w, "%s\t%s\t%s\n", key,
+		spillReplacer.Replace(is.RecordID), spillReplacer.Replace(is.SourceID))
+	return err
+}
+
+// Add registers the record id and DOI of a record.
+func (d *DuplicateDetector) Add(is finc.IntermediateSchema) error {
+	if err := d.add("id:"+is.RecordID, is); err != nil {
+		return err
+	}
+	if is.DOI == "" {
+		return nil
+	}
+	return d.add("doi:"+is.SourceID+":"+strings.ToLower(is.DOI), is)
+}
+
+// Issues confirms all candidates and returns an issue for each duplicated
+// record id and for each record sharing a DOI with another record of the same
+// source. The detector cannot be used afterwards.
+func (d *DuplicateDetector) Issues() ([]Issue, error) {
+	defer d.Close()
+	if err := d.w.Flush(); err != nil {
+		return nil, err
+	}
+	if _, err := d.spill.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	// Record ids per candidate key, in order of appearance.
+	found := make(map[string][]string)
+	sources := make(map[string]string)
+	br := bufio.NewReader(d.spill)
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		parts := strings.Split(strings.TrimRight(line, "\n"), "\t")
+		if len(parts) != 3 || !d.candidates[parts[0]] {
+			continue
+		}
+		found[parts[0]] = append(found[parts[0]], parts[1])
+		sources[parts[0]] = parts[2]
+	}
+
+	var keys []string
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var issues []Issue
+	for _, key := range keys {
+		ids := found[key]
+		switch {
+		case strings.HasPrefix(key, "id:"):
+			if len(ids) < 2 {
+				continue
+			}
+			issues = append(issues, Issue{Kind: DuplicateRecordID,
+				Record:  finc.IntermediateSchema{RecordID: ids[0], SourceID: sources[key]},
+				Message: fmt.Sprintf("%d occurrences", len(ids))})
+		case strings.HasPrefix(key, "doi:"):
+			distinct := unique(ids)
+			if len(distinct) < 2 {
+				continue
+			}
+			doi := strings.TrimPrefix(key, "doi:"+sources[key]+":")
+			for _, id := range distinct {
+				issues = append(issues, Issue{Kind: DuplicateDOI,
+					Record:  finc.IntermediateSchema{RecordID: id, SourceID: sources[key], DOI: doi},
+					Message: fmt.Sprintf("%s shared by %d records", doi, len(distinct))})
+			}
+		}
+	}
+	return issues, nil
+}
+
+// Close removes the spill file.
+func (d *DuplicateDetector) Close() error {
+	d.spill.Close()
+	return os.Remove(d.spill.Name())
+}
+
+// unique returns the distinct values in order of first appearance.
+func unique(values []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	return result
+}
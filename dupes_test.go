@@ -0,0 +1,44 @@
+package istools
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/miku/span/finc"
+)
+
+func TestDuplicateDetector(t *testing.T) {
+	d, err := NewDuplicateDetector(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []finc.IntermediateSchema{
+		{RecordID: "1", SourceID: "49", DOI: "10.1/a"},
+		{RecordID: "2", SourceID: "49", DOI: "10.1/A"},
+		{RecordID: "3", SourceID: "50", DOI: "10.1/a"},
+		{RecordID: "1", SourceID: "49"},
+		{RecordID: "4", SourceID: "49", DOI: "10.1/b"},
+		{RecordID: "4", SourceID: "49", DOI: "10.1/b"},
+	}
+	for i := 0; i < 500; i++ {
+		records = append(records, finc.IntermediateSchema{RecordID: fmt.Sprintf("x-%d", i), SourceID: "1"})
+	}
+	for _, is := range records {
+		if err := d.Add(is); err != nil {
+			t.Fatal(err)
+		}
+	}
+	issues, err := d.Issues()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, issue := range issues {
+		got = append(got, fmt.Sprintf("%s %s", issue.Kind, issue.Record.RecordID))
+	}
+	want := []string{"DuplicateDOI 1", "DuplicateDOI 2", "DuplicateRecordID 1", "DuplicateRecordID 4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
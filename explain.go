@@ -0,0 +1,93 @@
+package istools
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/miku/istools/coverage"
+	"github.com/miku/span/finc"
+)
+
+// Trace records the evaluation of a filter node. Children lists only the
+// nodes, that were actually evaluated, so short-circuited nodes are missing.
+type Trace struct {
+	Path  string `json:"path"`
+	Type  string `json:"type"`
+	Match bool   `json:"match"`
+	// Values found under the path of an attr node.
+	Values []string `json:"values,omitempty"`
+	// Verdict of a holding node.
+	Verdict  *coverage.Verdict `json:"verdict,omitempty"`
+	Children []Trace           `json:"children,omitempty"`
+}
+
+// Explain evaluates a filter just like Apply, but returns a trace. The path
+// names the node, e.g. an ISIL.
+func Explain(path string, f Filter, is finc.IntermediateSchema) Trace {
+	switch f := f.(type) {
+	case MatchAll:
+		return Trace{Path: path + ".match_all", Type: "match_all", Match: true}
+	case HoldingFilter:
+		verdict := coverage.Checker{Entries: f.Entries, Permissive: f.Permissive}.Check(is)
+		return Trace{Path: path + ".holding", Type: "holding", Match: verdict.Valid, Verdict: &verdict}
+	case AttrFilter:
+		values, _ := attrValues(is, f.Path)
+		return Trace{Path: path + ".attr", Type: "attr", Match: f.Apply(is), Values: values}
+	case OrFilter:
+		t := Trace{Path: path + ".or", Type: "or"}
+		for i, filter := range f.Filters {
+			child := Explain(fmt.Sprintf("%s[%d]", t.Path, i), filter, is)
+			t.Children = append(t.Children, child)
+			if child.Match {
+				t.Match = true
+				break
+			}
+		}
+		return t
+	case AndFilter:
+		t := Trace{Path: path + ".and", Type: "and", Match: true}
+		for i, filter := range f.Filters {
+			child := Explain(fmt.Sprintf("%s[%d]", t.Path, i), filter, is)
+			t.Children = append(t.Children, child)
+			if !child.Match {
+				t.Match = false
+				break
+			}
+		}
+		return t
+	case NotFilter:
+		child := Explain(path+".not", f.Filter, is)
+		return Trace{Path: path + ".not", Type: "not", Match: !child.Match, Children: []Trace{child}}
+	case ThresholdFilter:
+		t := Trace{Path: path + ".at_least", Type: "at_least"}
+		var matched int
+		for i, filter := range f.Filters {
+			if matched >= f.N || matched+len(f.Filters)-i < f.N {
+				break
+			}
+			child := Explain(fmt.Sprintf("%s.filters[%d]", t.Path, i), filter, is)
+			t.Children = append(t.Children, child)
+			if child.Match {
+				matched++
+			}
+		}
+		t.Match = matched >= f.N
+		return t
+	default:
+		return Trace{Path: path, Type: fmt.Sprintf("%T", f), Match: f.Apply(is)}
+	}
+}
+
+// Explain returns a trace for each ISIL, sorted by ISIL.
+func (t Tree) Explain(is finc.IntermediateSchema) []Trace {
+	var isils []string
+	for isil := range t {
+		isils = append(isils, isil)
+	}
+	sort.Strings(isils)
+	var traces []Trace
+	for _, isil := range isils {
+		traces = append(traces, Explain(isil, t[isil], is))
+	}
+	return traces
+}
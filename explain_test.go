@@ -0,0 +1,53 @@
+package istools
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/miku/span/finc"
+)
+
+func TestTreeExplain(t *testing.T) {
+	doc := `{
+		"A": {"or": [{"attr": {"path": "finc.source_id", "value": "48"}}, {"attr": {"path": "finc.source_id", "value": "49"}}, {"match_all": {}}]},
+		"B": {"and": [{"not": {"match_all": {}}}, {"match_all": {}}]},
+		"C": {"at_least": {"n": 2, "filters": [{"match_all": {}}, {"match_all": {}}, {"match_all": {}}]}}
+	}`
+	var tree Tree
+	if err := json.Unmarshal([]byte(doc), &tree); err != nil {
+		t.Fatal(err)
+	}
+	traces := tree.Explain(finc.IntermediateSchema{SourceID: "49"})
+
+	var cases = []struct {
+		about    string
+		trace    Trace
+		match    bool
+		children []string
+	}{
+		{"or stops at first match", traces[0], true, []string{"A.or[0].attr", "A.or[1].attr"}},
+		{"and stops at first failure", traces[1], false, []string{"B.and[0].not"}},
+		{"at_least stops when reached", traces[2], true, []string{"C.at_least.filters[0].match_all", "C.at_least.filters[1].match_all"}},
+	}
+	for _, c := range cases {
+		if c.trace.Match != c.match {
+			t.Errorf("%s: got %v, want %v", c.about, c.trace.Match, c.match)
+		}
+		var paths []string
+		for _, child := range c.trace.Children {
+			paths = append(paths, child.Path)
+		}
+		if !reflect.DeepEqual(paths, c.children) {
+			t.Errorf("%s: got %v, want %v", c.about, paths, c.children)
+		}
+	}
+	if values := traces[0].Children[1].Values; !reflect.DeepEqual(values, []string{"49"}) {
+		t.Errorf("attr values: got %v, want [49]", values)
+	}
+	for i, isil := range []string{"A", "B", "C"} {
+		if want := tree[isil].Apply(finc.IntermediateSchema{SourceID: "49"}); traces[i].Match != want {
+			t.Errorf("%s: got %v, want %v", isil, traces[i].Match, want)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package istools
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miku/span/finc"
+)
+
+// fieldIndexCache maps dotted paths to struct field indices.
+var fieldIndexCache sync.Map
+
+// jsonName returns the JSON name of a struct field or "" for skipped fields.
+func jsonName(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return ""
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name
+	}
+	return f.Name
+}
+
+// resolvePath finds the field indices for a dotted path, like
+// "authors.rft.aulast". Since JSON names of the intermediate schema contain
+// dots themselves, the longest matching name wins on each level. Slices are
+// traversed transparently.
+func resolvePath(t reflect.Type, path string) ([]int, error) {
+	for t.Kind() == reflect.Slice || t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if path == "" {
+		return nil, nil
+	}
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) {
+		return nil, fmt.Errorf("cannot resolve %q on %s", path, t)
+	}
+	var best = -1
+	var bestName string
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		if name == "" || len(name) <= len(bestName) {
+			continue
+		}
+		if path == name || strings.HasPrefix(path, name+".") {
+			best, bestName = i, name
+		}
+	}
+	if best < 0 {
+		return nil, fmt.Errorf("unknown field: %s", path)
+	}
+	rest, err := resolvePath(t.Field(best).Type, strings.TrimPrefix(strings.TrimPrefix(path, bestName), "."))
+	if err != nil {
+		return nil, err
+	}
+	return append([]int{best}, rest...), nil
+}
+
+// fieldIndex resolves a path against the intermediate schema, caching the
+// result.
+func fieldIndex(path string) ([]int, error) {
+	if v, ok := fieldIndexCache.Load(path); ok {
+		return v.([]int), nil
+	}
+	for _, segment := range strings.Split(path, ".") {
+		if segment == "" {
+			return nil, fmt.Errorf("empty segment in path: %q", path)
+		}
+	}
+	index, err := resolvePath(reflect.TypeOf(finc.IntermediateSchema{}), path)
+	if err != nil {
+		return nil, err
+	}
+	fieldIndexCache.Store(path, index)
+	return index, nil
+}
+
+// collectValues appends the string representations of all values found by
+// following index from v.
+func collectValues(v reflect.Value, index []int, values []string) []string {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return values
+		}
+		return collectValues(v.Elem(), index, values)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			values = collectValues(v.Index(i), index, values)
+		}
+		return values
+	}
+	if len(index) > 0 {
+		return collectValues(v.Field(index[0]), index[1:], values)
+	}
+	switch x := v.Interface().(type) {
+	case string:
+		return append(values, x)
+	case time.Time:
+		return append(values, x.Format(time.RFC3339))
+	case fmt.Stringer:
+		return append(values, x.String())
+	}
+	if v.CanAddr() {
+		if s, ok := v.Addr().Interface().(fmt.Stringer); ok {
+			return append(values, s.String())
+		}
+	}
+	switch v.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return append(values, fmt.Sprint(v.Interface()))
+	}
+	return values
+}
+
+// attrValues returns the values found under a dotted path, e.g.
+// "finc.source_id", "rft.issn" or "authors.rft.aulast".
+func attrValues(is finc.IntermediateSchema, path string) ([]string, error) {
+	index, err := fieldIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	return collectValues(reflect.ValueOf(&is).Elem(), index, nil), nil
+}
@@ -1,9 +1,552 @@
 package istools
 
-import "github.com/miku/span/finc"
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
 
+	"github.com/miku/holdings"
+	"github.com/miku/istools/coverage"
+	"github.com/miku/span/container"
+	"github.com/miku/span/finc"
+)
+
+// Filter returns true, if a record satisfies some criteria.
+type Filter interface {
+	Apply(finc.IntermediateSchema) bool
+}
+
+// MatchAll matches every record.
 type MatchAll struct{}
 
+// Apply always returns true.
 func (f MatchAll) Apply(is finc.IntermediateSchema) bool {
 	return true
 }
+
+// HoldingFilter matches records, that are covered by at least one license
+// from a holdings file.
+type HoldingFilter struct {
+	Entries holdings.Entries
+	// Permissive allows records, that cannot be checked.
+	Permissive bool
+}
+
+// Apply checks all ISSN of the record against the licenses.
+func (f HoldingFilter) Apply(is finc.IntermediateSchema) bool {
+	return coverage.Checker{Entries: f.Entries, Permissive: f.Permissive}.Check(is).Valid
+}
+
+// AttrFilter matches records by the value of a field, addressed by a dotted
+// path of JSON names, e.g. "finc.source_id", "rft.issn" or
+// "authors.rft.aulast". Array fields match, if any of their values matches.
+// Exactly one of Value, Pattern or Values should be set.
+type AttrFilter struct {
+	Path    string
+	Value   string
+	Pattern *regexp.Regexp
+	Values  *container.StringSet
+}
+
+// Apply returns true, if any of the values found under path match.
+func (f AttrFilter) Apply(is finc.IntermediateSchema) bool {
+	values, err := attrValues(is, f.Path)
+	if err != nil {
+		return false
+	}
+	for _, v := range values {
+		switch {
+		case f.Pattern != nil:
+			if f.Pattern.MatchString(v) {
+				return true
+			}
+		case f.Values != nil:
+			if f.Values.Contains(v) {
+				return true
+			}
+		default:
+			if v == f.Value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// OrFilter matches, if any of its filters match.
+type OrFilter struct {
+	Filters []Filter
+}
+
+// Apply returns true on the first matching filter.
+func (f OrFilter) Apply(is finc.IntermediateSchema) bool {
+	for _, filter := range f.Filters {
+		if filter.Apply(is) {
+			return true
+		}
+	}
+	return false
+}
+
+// AndFilter matches, if all of its filters match.
+type AndFilter struct {
+	Filters []Filter
+}
+
+// Apply returns false on the first filter, that does not match.
+func (f AndFilter) Apply(is finc.IntermediateSchema) bool {
+	for _, filter := range f.Filters {
+		if !filter.Apply(is) {
+			return false
+		}
+	}
+	return true
+}
+
+// NotFilter inverts a filter.
+type NotFilter struct {
+	Filter Filter
+}
+
+// Apply returns true, if the wrapped filter does not match.
+func (f NotFilter) Apply(is finc.IntermediateSchema) bool {
+	return !f.Filter.Apply(is)
+}
+
+// ThresholdFilter matches, if at least N of its filters match.
+type ThresholdFilter struct {
+	N       int
+	Filters []Filter
+}
+
+// Apply stops as soon as N filters matched or N cannot be reached anymore.
+func (f ThresholdFilter) Apply(is finc.IntermediateSchema) bool {
+	var matched int
+	for i, filter := range f.Filters {
+		if matched+len(f.Filters)-i < f.N {
+			return false
+		}
+		if filter.Apply(is) {
+			matched++
+		}
+		if matched >= f.N {
+			return true
+		}
+	}
+	return matched >= f.N
+}
+
+// ConfigError is an error in a filter tree configuration. Path locates the
+// offending node, e.g. "DE-15.or[1].attr".
+type ConfigError struct {
+	Path string
+	Err  error
+}
+
+// Error returns the path and the error.
+func (e ConfigError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Err)
+}
+
+// filterTypes are the known node names.
+var filterTypes = []string{"match_all", "holding", "attr", "or", "and", "not", "at_least"}
+
+// Tree maps an ISIL to the filter that decides, whether a record is licensed
+// for that institution.
+type Tree map[string]Filter
+
+// UnmarshalJSON builds the filter tree from a JSON document. If there are
+// problems, the first one is returned, see Validate for all of them.
+func (t *Tree) UnmarshalJSON(b []byte) error {
+	tree, errs := parseTree(b)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	*t = tree
+	return nil
+}
+
+// Apply returns the sorted list of ISILs whose filters match the record.
+func (t Tree) Apply(is finc.IntermediateSchema) []string {
+	var isils []string
+	for isil, filter := range t {
+		if filter.Apply(is) {
+			isils = append(isils, isil)
+		}
+	}
+	sort.Strings(isils)
+	return isils
+}
+
+// ReadTree reads a filter tree from a JSON file.
+func ReadTree(filename string) (Tree, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var tree Tree
+	if err := json.NewDecoder(f).Decode(&tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// Validate checks a filter tree document and reports all problems, ordered by
+// ISIL. All referenced files are read and all regular expressions compiled.
+func Validate(b []byte) []ConfigError {
+	_, errs := parseTree(b)
+	return errs
+}
+
+// ValidateFile runs Validate on a file.
+func ValidateFile(filename string) ([]ConfigError, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Validate(b), nil
+}
+
+// parseTree builds a tree and collects all errors on the way.
+func parseTree(b []byte) (Tree, []ConfigError) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, []ConfigError{{Err: err}}
+	}
+	var isils []string
+	for isil := range doc {
+		isils = append(isils, isil)
+	}
+	sort.Strings(isils)
+
+	p := newTreeParser()
+	tree := make(Tree)
+	for _, isil := range isils {
+		if filter := p.parse(isil, doc[isil]); filter != nil {
+			tree[isil] = filter
+		}
+	}
+	return tree, p.errs
+}
+
+// treeParser builds filters from JSON. Holdings files are read only once per
+// tree, even if they are referenced by many nodes. Parsing does not stop at
+// the first error, all errors are collected.
+type treeParser struct {
+	entries map[string]cachedHoldings
+	errs    []ConfigError
+}
+
+// cachedHoldings are the entries read from a file along with the error, that
+// occured while reading, if any.
+type cachedHoldings struct {
+	entries holdings.Entries
+	err     error
+}
+
+// newTreeParser creates a parser with an empty holdings cache.
+func newTreeParser() *treeParser {
+	return &treeParser{entries: make(map[string]cachedHoldings)}
+}
+
+// fail records an error at a path.
+func (p *treeParser) fail(path string, err error) {
+	p.errs = append(p.errs, ConfigError{Path: path, Err: err})
+}
+
+// readHoldings reads a holdings file or returns it from the cache. An empty
+// format is detected from the file contents. If ignoreErrors is set, the
+// entries, that could be parsed are used despite unparsable lines.
+func (p *treeParser) readHoldings(location, format string, ignoreErrors bool) (holdings.Entries, error) {
+	if format == "" {
+		var err error
+		if format, err = coverage.DetectFormat(location); err != nil {
+			return nil, err
+		}
+	}
+	key := format + ":" + location
+	cached, ok := p.entries[key]
+	if !ok {
+		entries, err := coverage.ReadFile(location, format)
+		cached = cachedHoldings{entries: entries, err: err}
+		p.entries[key] = cached
+	}
+	if cached.err != nil {
+		if _, ok := cached.err.(holdings.ParseError); ok && ignoreErrors {
+			return cached.entries, nil
+		}
+		return nil, fmt.Errorf("%s: %s", location, cached.err)
+	}
+	return cached.entries, nil
+}
+
+// decodeStrict decodes options and rejects unknown keys, which are most
+// likely typos.
+func decodeStrict(raw json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// parse turns a single node, like {"or": [...]} into a filter. The path is
+// used in error messages. Returns nil, if the node is invalid.
+func (p *treeParser) parse(path string, b []byte) Filter {
+	var node map[string]json.RawMessage
+	if err := json.Unmarshal(b, &node); err != nil {
+		p.fail(path, err)
+		return nil
+	}
+	if len(node) != 1 {
+		p.fail(path, fmt.Errorf("filter node must have exactly one key, got %d", len(node)))
+		return nil
+	}
+	for name, raw := range node {
+		path = path + "." + name
+		switch name {
+		case "match_all":
+			var options struct{}
+			if err := decodeStrict(raw, &options); err != nil {
+				p.fail(path, err)
+				return nil
+			}
+			return MatchAll{}
+		case "holding":
+			return p.parseHolding(path, raw)
+		case "attr":
+			return p.parseAttr(path, raw)
+		case "or", "and":
+			filters, ok := p.parseList(path, raw)
+			if !ok {
+				return nil
+			}
+			if name == "or" {
+				return OrFilter{Filters: filters}
+			}
+			return AndFilter{Filters: filters}
+		case "not":
+			if f := p.parse(path, raw); f != nil {
+				return NotFilter{Filter: f}
+			}
+			return nil
+		case "at_least":
+			var options struct {
+				N       int             `json:"n"`
+				Filters json.RawMessage `json:"filters"`
+			}
+			if err := decodeStrict(raw, &options); err != nil {
+				p.fail(path, err)
+				return nil
+			}
+			filters, ok := p.parseList(path+".filters", options.Filters)
+			if len(filters) > 0 && (options.N < 1 || options.N > len(filters)) {
+				p.fail(path+".n", fmt.Errorf("at_least requires 1 <= n <= %d, got %d", len(filters), options.N))
+				return nil
+			}
+			if !ok {
+				return nil
+			}
+			return ThresholdFilter{N: options.N, Filters: filters}
+		default:
+			err := fmt.Errorf("unknown filter type: %s", name)
+			if suggestion := closest(name, filterTypes); suggestion != "" {
+				err = fmt.Errorf("unknown filter type: %s, did you mean %s?", name, suggestion)
+			}
+			p.fail(path, err)
+			return nil
+		}
+	}
+	return nil
+}
+
+// parseList parses a non-empty JSON array of nodes. Reports false, if any
+// node is invalid.
+func (p *treeParser) parseList(path string, raw json.RawMessage) ([]Filter, bool) {
+	if raw == nil {
+		p.fail(path, fmt.Errorf("missing list of filters"))
+		return nil, false
+	}
+	var raws []json.RawMessage
+	if err := json.Unmarshal(raw, &raws); err != nil {
+		p.fail(path, err)
+		return nil, false
+	}
+	if len(raws) == 0 {
+		p.fail(path, fmt.Errorf("empty list of filters"))
+		return nil, false
+	}
+	var filters []Filter
+	ok := true
+	for i, r := range raws {
+		if filter := p.parse(fmt.Sprintf("%s[%d]", path, i), r); filter != nil {
+			filters = append(filters, filter)
+		} else {
+			ok = false
+		}
+	}
+	return filters, ok
+}
+
+// parseHolding parses the options of a holding node.
+func (p *treeParser) parseHolding(path string, raw json.RawMessage) Filter {
+	var options struct {
+		Location     string `json:"location"`
+		Format       string `json:"format"`
+		Permissive   bool   `json:"permissive"`
+		IgnoreErrors bool   `json:"ignore_errors"`
+	}
+	if err := decodeStrict(raw, &options); err != nil {
+		p.fail(path, err)
+		return nil
+	}
+	if options.Location == "" {
+		p.fail(path+".location", fmt.Errorf("holding requires a location"))
+		return nil
+	}
+	entries, err := p.readHoldings(options.Location, options.Format, options.IgnoreErrors)
+	if err != nil {
+		p.fail(path+".location", err)
+		return nil
+	}
+	return HoldingFilter{Entries: entries, Permissive: options.Permissive}
+}
+
+// parseAttr parses the options of an attr node.
+func (p *treeParser) parseAttr(path string, raw json.RawMessage) Filter {
+	var options struct {
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+		Regex string          `json:"regex"`
+		List  string          `json:"list"`
+	}
+	if err := decodeStrict(raw, &options); err != nil {
+		p.fail(path, err)
+		return nil
+	}
+	n := len(p.errs)
+	if options.Path == "" {
+		p.fail(path+".path", fmt.Errorf("attr requires a path"))
+	} else if _, err := fieldIndex(options.Path); err != nil {
+		p.fail(path+".path", err)
+	}
+	var set int
+	for _, ok := range []bool{options.Value != nil, options.Regex != "", options.List != ""} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		p.fail(path, fmt.Errorf("attr requires exactly one of value, regex or list"))
+	}
+	filter := AttrFilter{Path: options.Path}
+	if options.Value != nil {
+		// Allow numbers and booleans as well as strings.
+		var v interface{}
+		if err := json.Unmarshal(options.Value, &v); err != nil {
+			p.fail(path+".value", err)
+		}
+		switch v.(type) {
+		case string, float64, bool:
+			filter.Value = fmt.Sprint(v)
+		default:
+			p.fail(path+".value", fmt.Errorf("value must be a string, number or boolean, got %s", options.Value))
+		}
+	}
+	if options.Regex != "" {
+		pattern, err := regexp.Compile(options.Regex)
+		if err != nil {
+			p.fail(path+".regex", err)
+		}
+		filter.Pattern = pattern
+	}
+	if options.List != "" {
+		values, err := readStringSet(options.List)
+		if err != nil {
+			p.fail(path+".list", err)
+		}
+		filter.Values = values
+	}
+	if len(p.errs) > n {
+		return nil
+	}
+	return filter
+}
+
+// closest returns the candidate with the smallest edit distance to s, if it
+// is close enough to be a typo.
+func closest(s string, candidates []string) string {
+	var best string
+	min := 3
+	for _, c := range candidates {
+		if d := levenshtein(s, c); d < min {
+			best, min = c, d
+		}
+	}
+	return best
+}
+
+// levenshtein returns the edit distance of two strings.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// minInt returns the smallest of the given ints.
+func minInt(v int, vs ...int) int {
+	for _, w := range vs {
+		if w < v {
+			v = w
+		}
+	}
+	return v
+}
+
+// readStringSet reads a newline delimited file into a set, skipping empty
+// lines and comments starting with #.
+func readStringSet(filename string) (*container.StringSet, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := container.NewStringSet()
+	br := bufio.NewReader(f)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			s.Add(line)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return s, nil
+}
@@ -1 +1,269 @@
 package istools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miku/holdings"
+	"github.com/miku/span/finc"
+)
+
+func TestTreeUnmarshalJSON(t *testing.T) {
+	var cases = []struct {
+		about string
+		doc   string
+		is    finc.IntermediateSchema
+		isils []string
+	}{
+		{
+			about: "match all",
+			doc:   `{"A": {"match_all": {}}}`,
+			is:    finc.IntermediateSchema{},
+			isils: []string{"A"},
+		},
+		{
+			about: "attr value",
+			doc:   `{"A": {"attr": {"path": "finc.source_id", "value": "49"}}, "B": {"attr": {"path": "finc.source_id", "value": "48"}}}`,
+			is:    finc.IntermediateSchema{SourceID: "49"},
+			isils: []string{"A"},
+		},
+		{
+			about: "attr regex on array field",
+			doc:   `{"A": {"attr": {"path": "rft.issn", "regex": "^1234-"}}}`,
+			is:    finc.IntermediateSchema{ISSN: []string{"0000-0000", "1234-5678"}},
+			isils: []string{"A"},
+		},
+		{
+			about: "attr nested path through array",
+			doc:   `{"A": {"attr": {"path": "authors.rft.aulast", "value": "Czygan"}}}`,
+			is:    finc.IntermediateSchema{Authors: []finc.Author{{LastName: "Smith"}, {LastName: "Czygan"}}},
+			isils: []string{"A"},
+		},
+		{
+			about: "attr regex on date",
+			doc:   `{"A": {"attr": {"path": "x.date", "regex": "^2015-"}}, "B": {"attr": {"path": "x.date", "regex": "^2016-"}}}`,
+			is:    finc.IntermediateSchema{Date: time.Date(2015, 6, 1, 0, 0, 0, 0, time.UTC)},
+			isils: []string{"A"},
+		},
+		{
+			about: "attr numeric value",
+			doc:   `{"A": {"attr": {"path": "finc.source_id", "value": 49}}}`,
+			is:    finc.IntermediateSchema{SourceID: "49"},
+			isils: []string{"A"},
+		},
+		{
+			about: "and, or",
+			doc: `{
+				"A": {"and": [{"match_all": {}}, {"attr": {"path": "finc.source_id", "value": "48"}}]},
+				"B": {"or": [{"attr": {"path": "finc.source_id", "value": "1"}}, {"attr": {"path": "finc.source_id", "value": "49"}}]},
+				"C": {"or": [{"match_all": {}}]}
+			}`,
+			is:    finc.IntermediateSchema{SourceID: "49"},
+			isils: []string{"B", "C"},
+		},
+		{
+			about: "not",
+			doc:   `{"A": {"not": {"attr": {"path": "finc.source_id", "value": "49"}}}, "B": {"not": {"attr": {"path": "finc.source_id", "value": "48"}}}}`,
+			is:    finc.IntermediateSchema{SourceID: "49"},
+			isils: []string{"B"},
+		},
+		{
+			about: "at least",
+			doc: `{
+				"A": {"at_least": {"n": 2, "filters": [{"match_all": {}}, {"attr": {"path": "finc.source_id", "value": "48"}}, {"attr": {"path": "finc.source_id", "value": "49"}}]}},
+				"B": {"at_least": {"n": 2, "filters": [{"match_all": {}}, {"attr": {"path": "finc.source_id", "value": "48"}}]}}
+			}`,
+			is:    finc.IntermediateSchema{SourceID: "49"},
+			isils: []string{"A"},
+		},
+	}
+
+	for _, c := range cases {
+		var tree Tree
+		if err := json.Unmarshal([]byte(c.doc), &tree); err != nil {
+			t.Errorf("%s: got %v, want nil", c.about, err)
+			continue
+		}
+		isils := tree.Apply(c.is)
+		if !reflect.DeepEqual(isils, c.isils) {
+			t.Errorf("%s: got %v, want %v", c.about, isils, c.isils)
+		}
+	}
+}
+
+func TestTreeUnmarshalJSONErrors(t *testing.T) {
+	var cases = []string{
+		`{"A": {"holdng": {}}}`,
+		`{"A": {"match_all": {}, "or": []}}`,
+		`{"A": {"attr": {"path": "x", "regex": "("}}}`,
+		`{"A": {"or": {}}}`,
+		`{"A": {"attr": {"path": "x.unknown", "value": "1"}}}`,
+		`{"A": {"attr": {"path": "finc.source_id", "value": "1", "regex": "1"}}}`,
+		`{"A": {"attr": {"path": "finc.source_id"}}}`,
+		`{"A": {"at_least": {"n": 0, "filters": [{"match_all": {}}]}}}`,
+		`{"A": {"at_least": {"n": 2, "filters": [{"match_all": {}}]}}}`,
+	}
+	for _, c := range cases {
+		var tree Tree
+		if err := json.Unmarshal([]byte(c), &tree); err == nil {
+			t.Errorf("%s: got nil, want error", c)
+		}
+	}
+}
+
+func TestAttrFilterList(t *testing.T) {
+	f, err := ioutil.TempFile("", "istools-list-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	io.WriteString(f, "# sources\n48\n\n  49  \n50")
+	f.Close()
+
+	doc := fmt.Sprintf(`{"A": {"attr": {"path": "finc.source_id", "list": %q}}}`, f.Name())
+	var tree Tree
+	if err := json.Unmarshal([]byte(doc), &tree); err != nil {
+		t.Fatal(err)
+	}
+	for _, sid := range []string{"48", "49", "50"} {
+		if isils := tree.Apply(finc.IntermediateSchema{SourceID: sid}); len(isils) != 1 {
+			t.Errorf("%s: got %v, want [A]", sid, isils)
+		}
+	}
+	for _, sid := range []string{"# sources", "51", ""} {
+		if isils := tree.Apply(finc.IntermediateSchema{SourceID: sid}); len(isils) != 0 {
+			t.Errorf("%s: got %v, want []", sid, isils)
+		}
+	}
+}
+
+func TestConfigErrorPath(t *testing.T) {
+	var cases = []struct {
+		doc  string
+		path string
+	}{
+		{`{"A": {"holdng": {}}}`, "A.holdng"},
+		{`{"A": {"or": [{"match_all": {}}, {"and": [{"match_all": {}}, {"nto": {}}]}]}}`, "A.or[1].and[1].nto"},
+		{`{"A": {"not": {"attr": {"path": "x.unknown", "value": "1"}}}}`, "A.not.attr.path"},
+		{`{"A": {"at_least": {"n": 1, "filters": [{"foo": {}}]}}}`, "A.at_least.filters[0].foo"},
+	}
+	for _, c := range cases {
+		var tree Tree
+		err := json.Unmarshal([]byte(c.doc), &tree)
+		ce, ok := err.(ConfigError)
+		if !ok {
+			t.Errorf("%s: got %v, want ConfigError", c.doc, err)
+			continue
+		}
+		if ce.Path != c.path {
+			t.Errorf("%s: got %v, want %v", c.doc, ce.Path, c.path)
+		}
+	}
+}
+
+// countingFilter records how often it was applied.
+type countingFilter struct {
+	result bool
+	n      *int
+}
+
+func (f countingFilter) Apply(is finc.IntermediateSchema) bool {
+	*f.n++
+	return f.result
+}
+
+func TestThresholdFilterShortCircuit(t *testing.T) {
+	var cases = []struct {
+		about   string
+		n       int
+		results []bool
+		want    bool
+		applied int
+	}{
+		{"reached early", 1, []bool{true, false, false}, true, 1},
+		{"unreachable early", 3, []bool{false, true, true}, false, 1},
+		{"needs all", 2, []bool{true, false, true}, true, 3},
+	}
+	for _, c := range cases {
+		var applied int
+		var filters []Filter
+		for _, r := range c.results {
+			filters = append(filters, countingFilter{result: r, n: &applied})
+		}
+		got := ThresholdFilter{N: c.n, Filters: filters}.Apply(finc.IntermediateSchema{})
+		if got != c.want {
+			t.Errorf("%s: got %v, want %v", c.about, got, c.want)
+		}
+		if applied != c.applied {
+			t.Errorf("%s: got %v, want %v", c.about, applied, c.applied)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	doc := `{
+		"A": {"holdng": {"location": "/does/not/exist"}},
+		"B": {"or": []},
+		"C": {"and": [{"attr": {"path": "x.unknown", "regex": "("}}, {"attr": {"path": "finc.source_id", "list": "/does/not/exist"}}]},
+		"D": {"holding": {"locaton": "/does/not/exist"}},
+		"E": {"holding": {"location": "/does/not/exist", "format": "kbart"}},
+		"F": {"at_least": {"n": 3, "filters": [{"match_all": {}}]}},
+		"G": {"match_all": {}},
+		"H": {"attr": {"value": "49"}},
+		"I": {"attr": {"path": "finc.source_id.", "value": "49"}},
+		"J": {"attr": {"path": "finc.source_id", "value": null}},
+		"K": {"or": [{"match_all": {"x": 1}}, {"match_all": 5}]}
+	}`
+	var paths []string
+	for _, err := range Validate([]byte(doc)) {
+		paths = append(paths, err.Path)
+	}
+	want := []string{
+		"A.holdng",
+		"B.or",
+		"C.and[0].attr.path",
+		"C.and[0].attr.regex",
+		"C.and[1].attr.list",
+		"D.holding",
+		"E.holding.location",
+		"F.at_least.n",
+		"H.attr.path",
+		"I.attr.path",
+		"J.attr.value",
+		"K.or[0].match_all",
+		"K.or[1].match_all",
+	}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got %v, want %v", paths, want)
+	}
+	if errs := Validate([]byte(`{"A": {"holdng": {}}}`)); len(errs) != 1 || !strings.Contains(errs[0].Error(), "did you mean holding?") {
+		t.Errorf("got %v, want suggestion", errs)
+	}
+}
+
+func TestReadHoldingsIgnoreErrors(t *testing.T) {
+	p := newTreeParser()
+	entries := holdings.Entries{"1234-5678": nil}
+	p.entries["kbart:/path/to/file"] = cachedHoldings{
+		entries: entries,
+		err:     holdings.ParseError{Line: 2, Err: errors.New("invalid line")},
+	}
+	if _, err := p.readHoldings("/path/to/file", "kbart", false); err == nil {
+		t.Errorf("got nil, want error")
+	}
+	got, err := p.readHoldings("/path/to/file", "kbart", true)
+	if err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("got %v, want %v", got, entries)
+	}
+}
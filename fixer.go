@@ -0,0 +1,150 @@
+package istools
+
+import (
+	"fmt"
+	"html"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/miku/span/finc"
+)
+
+var (
+	// issnLoosePattern matches ISSN with missing or odd separators, e.g. 12345678 or 1234 567x.
+	issnLoosePattern = regexp.MustCompile(`^([0-9]{4})[- ]?([0-9]{3}[0-9Xx])$`)
+	// repeatedSlashPattern matches runs of slashes.
+	repeatedSlashPattern = regexp.MustCompile(`/{2,}`)
+)
+
+// Change records a single modification made by a fixer.
+type Change struct {
+	RecordID string
+	Kind     Kind
+	Field    string
+	Old      string
+	New      string
+}
+
+// tsvEscaper escapes values, so they cannot break a TSV line.
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// TSV returns a tab representation. Tabs, newlines and backslashes in the old
+// and new values are escaped.
+func (c Change) TSV() string {
+	return fmt.Sprintf("%s\t%s\t%s\t%s\t%s", c.RecordID, c.Kind, c.Field,
+		tsvEscaper.Replace(c.Old), tsvEscaper.Replace(c.New))
+}
+
+// Fixer repairs a record in place and reports the changes made.
+type Fixer interface {
+	FixRecord(*finc.IntermediateSchema) []Change
+}
+
+// FixerFunc makes a function satisfy the Fixer interface.
+type FixerFunc func(*finc.IntermediateSchema) []Change
+
+// FixRecord delegates to the given func.
+func (f FixerFunc) FixRecord(is *finc.IntermediateSchema) []Change {
+	return f(is)
+}
+
+// DefaultFixers are applied by islint -fix, in order.
+var DefaultFixers = []Fixer{
+	FixerFunc(FixISSN),
+	FixerFunc(FixRepeatedSlash),
+	FixerFunc(FixAuthors),
+	FixerFunc(FixPublishers),
+}
+
+// FixISSN brings ISSN like 12345678 or 1234-567x into the canonical form.
+func FixISSN(is *finc.IntermediateSchema) []Change {
+	var changes []Change
+	fix := func(field string, values []string) {
+		for i, v := range values {
+			if issnPattern.MatchString(v) {
+				continue
+			}
+			m := issnLoosePattern.FindStringSubmatch(strings.TrimSpace(v))
+			if m == nil {
+				continue
+			}
+			values[i] = m[1] + "-" + strings.ToUpper(m[2])
+			changes = append(changes, Change{RecordID: is.RecordID, Kind: NonCanonicalISSN,
+				Field: field, Old: v, New: values[i]})
+		}
+	}
+	fix("rft.issn", is.ISSN)
+	fix("rft.eissn", is.EISSN)
+	return changes
+}
+
+// FixRepeatedSlash collapses repeated slashes in a DOI, refs. #6312.
+func FixRepeatedSlash(is *finc.IntermediateSchema) []Change {
+	if !strings.Contains(is.DOI, "//") {
+		return nil
+	}
+	old := is.DOI
+	is.DOI = repeatedSlashPattern.ReplaceAllString(is.DOI, "/")
+	return []Change{{RecordID: is.RecordID, Kind: RepeatedSlash, Field: "doi", Old: old, New: is.DOI}}
+}
+
+// FixAuthors drops whitespace only and "et al." authors and unescapes HTML
+// entities in author names.
+func FixAuthors(is *finc.IntermediateSchema) []Change {
+	var changes []Change
+	var authors []finc.Author
+	for _, author := range is.Authors {
+		s := author.String()
+		switch {
+		case len(s) > 0 && strings.TrimSpace(s) == "":
+			changes = append(changes, Change{RecordID: is.RecordID, Kind: WhitespaceAuthor,
+				Field: "authors", Old: s})
+			continue
+		case strings.HasPrefix(strings.ToLower(s), "et al"):
+			changes = append(changes, Change{RecordID: is.RecordID, Kind: EtAlAuthorName,
+				Field: "authors", Old: s})
+			continue
+		case htmlEntityPattern.MatchString(s):
+			unescapeStrings(&author)
+			changes = append(changes, Change{RecordID: is.RecordID, Kind: HTMLEntityInAuthorName,
+				Field: "authors", Old: s, New: author.String()})
+		}
+		authors = append(authors, author)
+	}
+	if len(changes) > 0 {
+		is.Authors = authors
+	}
+	return changes
+}
+
+// unescapeStrings unescapes HTML entities in all string fields of a struct,
+// given as pointer.
+func unescapeStrings(v interface{}) {
+	rv := reflect.ValueOf(v).Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		f := rv.Field(i)
+		if f.Kind() != reflect.String || !f.CanSet() {
+			continue
+		}
+		f.SetString(html.UnescapeString(f.String()))
+	}
+}
+
+// FixPublishers removes empty publisher names.
+func FixPublishers(is *finc.IntermediateSchema) []Change {
+	var changes []Change
+	var publishers []string
+	for _, p := range is.Publishers {
+		if strings.TrimSpace(p) == "" {
+			changes = append(changes, Change{RecordID: is.RecordID, Kind: NoPublisher,
+				Field: "rft.pub", Old: p})
+			continue
+		}
+		publishers = append(publishers, p)
+	}
+	if len(changes) > 0 {
+		is.Publishers = publishers
+	}
+	return changes
+}
@@ -0,0 +1,150 @@
+package istools
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// Processor reads newline delimited records in batches, transforms them in
+// parallel and writes the results in input order.
+type Processor struct {
+	BatchSize  int
+	NumWorkers int
+	// F transforms a single line. The result is written as is, so it should
+	// include a trailing newline, if needed.
+	F func([]byte) ([]byte, error)
+}
+
+// NewProcessor creates a processor with a default batch size and one worker
+// per CPU.
+func NewProcessor(f func([]byte) ([]byte, error)) *Processor {
+	return &Processor{BatchSize: 20000, NumWorkers: runtime.NumCPU(), F: f}
+}
+
+// work is a numbered batch of lines. Offset is the number of lines read
+// before this batch.
+type work struct {
+	seq    int
+	offset int
+	lines  [][]byte
+}
+
+// result holds the output of a single batch.
+type result struct {
+	seq int
+	buf bytes.Buffer
+	err error
+}
+
+// Run processes all lines from r and writes the results to w. Reading stops
+// after the first error, which is returned together with its line number.
+func (p *Processor) Run(r io.Reader, w io.Writer) error {
+	if p.BatchSize < 1 {
+		return fmt.Errorf("batch size must be positive, got %d", p.BatchSize)
+	}
+	if p.NumWorkers < 1 {
+		return fmt.Errorf("number of workers must be positive, got %d", p.NumWorkers)
+	}
+
+	queue := make(chan work)
+	out := make(chan *result)
+	done := make(chan error)
+
+	// stop is closed on the first error, so no more batches are queued.
+	stop := make(chan struct{})
+	var once sync.Once
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < p.NumWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range queue {
+				res := &result{seq: batch.seq}
+				for i, line := range batch.lines {
+					b, err := p.F(line)
+					if err != nil {
+						res.err = fmt.Errorf("line %d: %s", batch.offset+i+1, err)
+						once.Do(func() { close(stop) })
+						break
+					}
+					res.buf.Write(b)
+				}
+				out <- res
+			}
+		}()
+	}
+
+	// Collect results and write them in the order they were read.
+	go func() {
+		var next int
+		var firstErr error
+		pending := make(map[int]*result)
+		for res := range out {
+			pending[res.seq] = res
+			for {
+				res, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				if firstErr != nil {
+					continue
+				}
+				if res.err != nil {
+					firstErr = res.err
+					continue
+				}
+				if _, err := w.Write(res.buf.Bytes()); err != nil {
+					firstErr = err
+				}
+			}
+		}
+		done <- firstErr
+	}()
+
+	var seq, offset int
+	var readErr error
+	var batch [][]byte
+
+	br := bufio.NewReader(r)
+loop:
+	for {
+		b, err := br.ReadBytes('\n')
+		if len(b) > 0 {
+			batch = append(batch, b)
+		}
+		if len(batch) == p.BatchSize || (err != nil && len(batch) > 0) {
+			select {
+			case queue <- work{seq: seq, offset: offset, lines: batch}:
+			case <-stop:
+				break loop
+			}
+			offset += len(batch)
+			batch = nil
+			seq++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+	}
+
+	close(queue)
+	wg.Wait()
+	close(out)
+
+	if err := <-done; err != nil {
+		return err
+	}
+	return readErr
+}
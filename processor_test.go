@@ -0,0 +1,82 @@
+package istools
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestProcessorKeepsOrder(t *testing.T) {
+	var input, want bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "%d\n", i)
+		fmt.Fprintf(&want, "<%d>\n", i)
+	}
+	p := NewProcessor(func(b []byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("<%s>\n", strings.TrimSpace(string(b)))), nil
+	})
+	p.BatchSize = 7
+	p.NumWorkers = 4
+
+	var output bytes.Buffer
+	if err := p.Run(&input, &output); err != nil {
+		t.Fatalf("got %v, want nil", err)
+	}
+	if output.String() != want.String() {
+		t.Errorf("output out of order")
+	}
+}
+
+func TestProcessorError(t *testing.T) {
+	p := NewProcessor(func(b []byte) ([]byte, error) {
+		if string(b) == "x\n" {
+			return nil, fmt.Errorf("invalid line")
+		}
+		return b, nil
+	})
+	var output bytes.Buffer
+	err := p.Run(strings.NewReader("a\nb\nx\nc"), &output)
+	if err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Errorf("got %v, want error on line 3", err)
+	}
+}
+
+func TestProcessorStopsAfterError(t *testing.T) {
+	var input bytes.Buffer
+	for i := 0; i < 100000; i++ {
+		fmt.Fprintf(&input, "%d\n", i)
+	}
+	var calls int64
+	p := NewProcessor(func(b []byte) ([]byte, error) {
+		atomic.AddInt64(&calls, 1)
+		return nil, fmt.Errorf("invalid line")
+	})
+	p.BatchSize = 10
+	p.NumWorkers = 2
+	if err := p.Run(&input, ioutil.Discard); err == nil || !strings.HasPrefix(err.Error(), "line 1:") {
+		t.Errorf("got %v, want error on line 1", err)
+	}
+	if n := atomic.LoadInt64(&calls); n > 100 {
+		t.Errorf("got %d calls, want processing to stop early", n)
+	}
+}
+
+func TestProcessorInvalidSettings(t *testing.T) {
+	var cases = []struct {
+		batchSize, numWorkers int
+	}{
+		{0, 1},
+		{1, 0},
+		{-1, 4},
+	}
+	for _, c := range cases {
+		p := NewProcessor(func(b []byte) ([]byte, error) { return b, nil })
+		p.BatchSize, p.NumWorkers = c.batchSize, c.numWorkers
+		if err := p.Run(strings.NewReader("a\n"), ioutil.Discard); err == nil {
+			t.Errorf("%d, %d: got nil, want error", c.batchSize, c.numWorkers)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package istools
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Test is a named tester along with the kinds of issues it can report.
+type Test struct {
+	Name        string
+	Kinds       []Kind
+	Description string
+	// Refs are ticket references, e.g. "#5686".
+	Refs []string
+	// Tester checks a single record. It is nil for tests, that look at many
+	// records at once, like duplicate detection.
+	Tester Tester
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []Test
+)
+
+// Register adds a test to the registry. Registering a name twice panics.
+func Register(t Test) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, r := range registry {
+		if r.Name == t.Name {
+			panic(fmt.Sprintf("istools: test %s registered twice", t.Name))
+		}
+	}
+	registry = append(registry, t)
+	if t.Tester != nil {
+		DefaultTests = append(DefaultTests, t.Tester)
+	}
+}
+
+// Registered returns all registered tests, in order of registration.
+func Registered() []Test {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	tests := make([]Test, len(registry))
+	copy(tests, registry)
+	return tests
+}
+
+// Selection is a list of tests, whose reports are restricted to certain kinds.
+type Selection struct {
+	Tests []Test
+	kinds map[Kind]bool
+}
+
+// Allows returns true, if issues of a given kind should be reported.
+func (s Selection) Allows(k Kind) bool {
+	return s.kinds[k]
+}
+
+// Names returns the names of the selected tests.
+func (s Selection) Names() []string {
+	var names []string
+	for _, t := range s.Tests {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// Select picks tests by name or by the kind of issue they report. An empty
+// include list selects all tests. Names in exclude are removed from the
+// selection. A test is selected, as long as at least one of its kinds is.
+func Select(tests []Test, include, exclude []string) (Selection, error) {
+	byName := make(map[string][]Kind)
+	for _, t := range tests {
+		byName[t.Name] = t.Kinds
+		for _, k := range t.Kinds {
+			byName[k.String()] = []Kind{k}
+		}
+	}
+
+	kinds := make(map[Kind]bool)
+	if len(include) == 0 {
+		for _, t := range tests {
+			for _, k := range t.Kinds {
+				kinds[k] = true
+			}
+		}
+	}
+	for _, name := range include {
+		ks, ok := byName[name]
+		if !ok {
+			return Selection{}, fmt.Errorf("unknown test or kind: %s", name)
+		}
+		for _, k := range ks {
+			kinds[k] = true
+		}
+	}
+	for _, name := range exclude {
+		ks, ok := byName[name]
+		if !ok {
+			return Selection{}, fmt.Errorf("unknown test or kind: %s", name)
+		}
+		for _, k := range ks {
+			delete(kinds, k)
+		}
+	}
+
+	selection := Selection{kinds: kinds}
+	for _, t := range tests {
+		for _, k := range t.Kinds {
+			if kinds[k] {
+				selection.Tests = append(selection.Tests, t)
+				break
+			}
+		}
+	}
+	return selection, nil
+}
@@ -0,0 +1,66 @@
+package istools
+
+import "fmt"
+
+// Severity tells how serious an issue is.
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+// severityNames is written by hand instead of generated with stringer, because
+// ParseSeverity needs the reverse lookup as well.
+var severityNames = map[Severity]string{
+	SeverityInfo:    "info",
+	SeverityWarning: "warning",
+	SeverityError:   "error",
+}
+
+// String returns the lowercase name of the severity.
+func (s Severity) String() string {
+	if name, ok := severityNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// ParseSeverity parses a severity name, like "warning".
+func ParseSeverity(s string) (Severity, error) {
+	for k, v := range severityNames {
+		if v == s {
+			return k, nil
+		}
+	}
+	return SeverityInfo, fmt.Errorf("invalid severity: %s", s)
+}
+
+// severities assigns a severity to kinds. Kinds not listed here are warnings.
+var severities = map[Kind]Severity{
+	// Breaks memcachedb.
+	KeyTooLong: SeverityError,
+	// Record cannot be linked or attributed.
+	InvalidURL:          SeverityError,
+	NonAbsoluteURL:      SeverityError,
+	DisallowedURLScheme: SeverityError,
+	InvalidCollection:   SeverityError,
+	// Breaks holdings lookups.
+	NonCanonicalISSN: SeverityError,
+	// Record will be hidden or misplaced in date based views.
+	PublicationDateTooLate: SeverityError,
+
+	NoURL:                  SeverityInfo,
+	RepeatedSubtitle:       SeverityInfo,
+	ISSNPrintAndElectronic: SeverityInfo,
+	DuplicateURL:           SeverityInfo,
+}
+
+// Severity returns the severity of the kind.
+func (k Kind) Severity() Severity {
+	if s, ok := severities[k]; ok {
+		return s
+	}
+	return SeverityWarning
+}
@@ -0,0 +1,52 @@
+package istools
+
+import "testing"
+
+func TestParseSeverity(t *testing.T) {
+	var cases = []struct {
+		s        string
+		severity Severity
+		err      bool
+	}{
+		{"info", SeverityInfo, false},
+		{"warning", SeverityWarning, false},
+		{"error", SeverityError, false},
+		{"Error", SeverityInfo, true},
+		{"", SeverityInfo, true},
+		{"fatal", SeverityInfo, true},
+	}
+	for _, c := range cases {
+		severity, err := ParseSeverity(c.s)
+		if (err != nil) != c.err {
+			t.Errorf("%q: got %v, want error %v", c.s, err, c.err)
+		}
+		if severity != c.severity {
+			t.Errorf("%q: got %v, want %v", c.s, severity, c.severity)
+		}
+	}
+	for _, s := range []Severity{SeverityInfo, SeverityWarning, SeverityError} {
+		if parsed, err := ParseSeverity(s.String()); err != nil || parsed != s {
+			t.Errorf("%s: got %v, %v, want round trip", s, parsed, err)
+		}
+	}
+}
+
+func TestKindSeverity(t *testing.T) {
+	var cases = []struct {
+		kind     Kind
+		severity Severity
+	}{
+		{KeyTooLong, SeverityError},
+		{NonCanonicalISSN, SeverityError},
+		{NoURL, SeverityInfo},
+		{DuplicateURL, SeverityInfo},
+		// Not listed, defaults to warning.
+		{InvalidDOIPrefix, SeverityWarning},
+		{DuplicateRecordID, SeverityWarning},
+	}
+	for _, c := range cases {
+		if severity := c.kind.Severity(); severity != c.severity {
+			t.Errorf("%s: got %v, want %v", c.kind, severity, c.severity)
+		}
+	}
+}
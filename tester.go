@@ -1,15 +1,18 @@
 package istools
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/miku/istools/assetutil"
 	"github.com/miku/span"
+	"github.com/miku/span/container"
 	"github.com/miku/span/finc"
 )
 
@@ -38,6 +41,23 @@ const (
 	NoURL
 	NonCanonicalISSN
 	HTMLEntityInAuthorName
+	InvalidISSNCheckDigit
+	ISSNPrintAndElectronic
+	InvalidISBN
+	InvalidISBNCheckDigit
+	NonCanonicalISBN
+	ISBNMismatch
+	InvalidDOIPrefix
+	DOIWithURLPrefix
+	WhitespaceInDOI
+	TrailingPunctuationInDOI
+	DOIURLMismatch
+	NonAbsoluteURL
+	DisallowedURLScheme
+	BlockedURLHost
+	DuplicateURL
+	DuplicateRecordID
+	DuplicateDOI
 )
 
 var (
@@ -50,6 +70,12 @@ var (
 	AllowedCollections = assetutil.MustLoadStringSet("assets/collections/collections.tsv",
 		"assets/collections/crossref.tsv")
 
+	// AllowedURLSchemes are the lowercase URL schemes accepted by URLPolicy.
+	AllowedURLSchemes = container.NewStringSet("http", "https")
+	// BlockedURLHosts are hosts, that must not appear in URLs, like proxies.
+	// Subdomains of blocked hosts are blocked as well.
+	BlockedURLHosts = container.NewStringSet("localhost", "127.0.0.1")
+
 	// currencyPattern is a rather narrow pattern:
 	// http://rubular.com/r/WjcnjhckZq, used by NoCurrencyInTitle
 	currencyPattern = regexp.MustCompile(`[€$¥][+-]?[0-9]{1,3}(?:[0-9]*(?:[.,][0-9]{2})?|(?:,[0-9]{3})*(?:\.[0-9]{2})?|(?:\.[0-9]{3})*(?:,[0-9]{2})?)`)
@@ -59,6 +85,13 @@ var (
 	issnPattern = regexp.MustCompile(`^[0-9]{4,4}-[0-9]{3,3}[0-9X]$`)
 	// htmlEntityPattern looks for leftover entities: http://rubular.com/r/flzmBzpShX
 	htmlEntityPattern = regexp.MustCompile(`&(?:[a-z\d]+|#\d+|#x[a-f\d]+);`)
+	// isbn10Pattern and isbn13Pattern match ISBN without separators
+	isbn10Pattern = regexp.MustCompile(`^[0-9]{9}[0-9X]$`)
+	isbn13Pattern = regexp.MustCompile(`^97[89][0-9]{10}$`)
+	// doiPattern requires the 10. directory indicator, a registrant code and a suffix
+	doiPattern = regexp.MustCompile(`^10\.[0-9]{4,}(?:\.[0-9]+)*/.+`)
+	// doiURLPrefixPattern matches resolver prefixes, that do not belong into a DOI
+	doiURLPrefixPattern = regexp.MustCompile(`(?i)^(?:https?://(?:dx\.)?doi\.org/|doi:\s*)`)
 )
 
 // Issue contains information about a quality issue in an intermediate schema
@@ -79,6 +112,46 @@ func (e Issue) TSV() string {
 	return fmt.Sprintf("%s\t%s\t%s", e.Record.RecordID, e.Kind, e.Message)
 }
 
+// MarshalJSON returns a flat representation, that is safe to use for messages
+// containing tabs or newlines.
+func (e Issue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		RecordID   string `json:"id"`
+		SourceID   string `json:"source"`
+		Collection string `json:"collection"`
+		Kind       string `json:"kind"`
+		Severity   string `json:"severity"`
+		Message    string `json:"message"`
+	}{
+		RecordID:   e.Record.RecordID,
+		SourceID:   e.Record.SourceID,
+		Collection: e.Record.MegaCollection,
+		Kind:       e.Kind.String(),
+		Severity:   e.Kind.Severity().String(),
+		Message:    e.Message,
+	})
+}
+
+// MultiIssue collects all issues a single test found in a record.
+type MultiIssue []Issue
+
+// Error formats all issues.
+func (m MultiIssue) Error() string {
+	var messages []string
+	for _, issue := range m {
+		messages = append(messages, issue.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Err returns nil, if there are no issues, so testers can return it directly.
+func (m MultiIssue) Err() error {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
 // TestSuite is a group of tests.
 type TestSuite []Tester
 
@@ -95,20 +168,78 @@ func (f TesterFunc) TestRecord(is finc.IntermediateSchema) error {
 	return f(is)
 }
 
-var DefaultTests = []Tester{
-	TesterFunc(KeyLength),
-	TesterFunc(PlausiblePageCount),
-	TesterFunc(ValidURL),
-	TesterFunc(PlausibleDate),
-	TesterFunc(AllowedCollectionNames),
-	TesterFunc(SubtitleRepetition),
-	TesterFunc(NoCurrencyInTitle),
-	TesterFunc(NoExcessivePunctuation),
-	TesterFunc(HasPublisher),
-	TesterFunc(FeasibleAuthor),
-	TesterFunc(NoRepeatedSlash),
-	TesterFunc(HasURL),
-	TesterFunc(CanonicalISSN),
+// DefaultTests are the testers of all registered tests.
+var DefaultTests []Tester
+
+func init() {
+	Register(Test{Name: "KeyLength", Tester: TesterFunc(KeyLength),
+		Kinds:       []Kind{KeyTooLong},
+		Description: "record id must not exceed the memcachedb key length limit"})
+	Register(Test{Name: "PlausiblePageCount", Tester: TesterFunc(PlausiblePageCount),
+		Kinds:       []Kind{InvalidStartPage, InvalidEndPage, EndPageBeforeStartPage, SuspiciousPageCount},
+		Description: "start and end page must be numbers and span a plausible range"})
+	Register(Test{Name: "ValidURL", Tester: TesterFunc(ValidURL),
+		Kinds:       []Kind{InvalidURL},
+		Description: "URLs must be parseable"})
+	Register(Test{Name: "PlausibleDate", Tester: TesterFunc(PlausibleDate),
+		Kinds:       []Kind{PublicationDateTooEarly, PublicationDateTooLate},
+		Description: "publication date must lie between EarliestDate and LatestDate",
+		Refs:        []string{"#5686"}})
+	Register(Test{Name: "AllowedCollectionNames", Tester: TesterFunc(AllowedCollectionNames),
+		Kinds:       []Kind{InvalidCollection},
+		Description: "collection name must be in the list of allowed collections",
+		Refs:        []string{"#6496"}})
+	Register(Test{Name: "SubtitleRepetition", Tester: TesterFunc(SubtitleRepetition),
+		Kinds:       []Kind{RepeatedSubtitle},
+		Description: "subtitle must not be repeated in the title",
+		Refs:        []string{"#6553"}})
+	Register(Test{Name: "NoCurrencyInTitle", Tester: TesterFunc(NoCurrencyInTitle),
+		Kinds:       []Kind{CurrencyInTitle},
+		Description: "title must not contain prices, as found in book reviews"})
+	Register(Test{Name: "NoExcessivePunctuation", Tester: TesterFunc(NoExcessivePunctuation),
+		Kinds:       []Kind{ExcessivePunctuation},
+		Description: "title must not contain long runs of punctuation"})
+	Register(Test{Name: "HasPublisher", Tester: TesterFunc(HasPublisher),
+		Kinds:       []Kind{NoPublisher},
+		Description: "record must have a non-empty publisher"})
+	Register(Test{Name: "FeasibleAuthor", Tester: TesterFunc(FeasibleAuthor),
+		Kinds:       []Kind{ShortAuthorName, EtAlAuthorName, NAInAuthorName, WhitespaceAuthor, HTMLEntityInAuthorName},
+		Description: "author names must not be too short, placeholders or contain markup",
+		Refs:        []string{"#4892", "#4940", "#5895"}})
+	Register(Test{Name: "NoRepeatedSlash", Tester: TesterFunc(NoRepeatedSlash),
+		Kinds:       []Kind{RepeatedSlash},
+		Description: "DOI must not contain repeated slashes",
+		Refs:        []string{"#6312"}})
+	Register(Test{Name: "HasURL", Tester: TesterFunc(HasURL),
+		Kinds:       []Kind{NoURL},
+		Description: "record must have at least one URL"})
+	Register(Test{Name: "CanonicalISSN", Tester: TesterFunc(CanonicalISSN),
+		Kinds:       []Kind{NonCanonicalISSN},
+		Description: "ISSN must be in the canonical 1234-567X form"})
+	Register(Test{Name: "ISSNCheckDigit", Tester: TesterFunc(ISSNCheckDigit),
+		Kinds:       []Kind{InvalidISSNCheckDigit},
+		Description: "ISSN and EISSN must have a valid mod 11 check digit"})
+	Register(Test{Name: "DistinctISSN", Tester: TesterFunc(DistinctISSN),
+		Kinds:       []Kind{ISSNPrintAndElectronic},
+		Description: "the same ISSN must not be used for print and electronic"})
+	Register(Test{Name: "ValidISBN", Tester: TesterFunc(ValidISBN),
+		Kinds:       []Kind{InvalidISBN, InvalidISBNCheckDigit, NonCanonicalISBN},
+		Description: "ISBN and EISBN must be valid ISBN-10 or ISBN-13, hyphenated consistently"})
+	Register(Test{Name: "MatchingISBN", Tester: TesterFunc(MatchingISBN),
+		Kinds:       []Kind{ISBNMismatch},
+		Description: "each ISBN-10 must correspond to an ISBN-13, if both are given"})
+	Register(Test{Name: "ValidDOI", Tester: TesterFunc(ValidDOI),
+		Kinds:       []Kind{InvalidDOIPrefix, DOIWithURLPrefix, WhitespaceInDOI, TrailingPunctuationInDOI},
+		Description: "DOI must start with 10. and a registrant code, without resolver prefix, whitespace or trailing punctuation"})
+	Register(Test{Name: "DOIMatchesURL", Tester: TesterFunc(DOIMatchesURL),
+		Kinds:       []Kind{DOIURLMismatch},
+		Description: "DOI found in a doi.org URL must match the DOI field"})
+	Register(Test{Name: "URLPolicy", Tester: TesterFunc(URLPolicy),
+		Kinds:       []Kind{NonAbsoluteURL, DisallowedURLScheme, BlockedURLHost, DuplicateURL},
+		Description: "URLs must be absolute, use an allowed scheme, no blocked host and be listed once"})
+	Register(Test{Name: "Duplicates",
+		Kinds:       []Kind{DuplicateRecordID, DuplicateDOI},
+		Description: "record ids must be unique and DOI unique within a source, checked across records"})
 }
 
 // KeyLength checks the length of the record id. memcachedb limits this to 250
@@ -122,12 +253,13 @@ func KeyLength(is finc.IntermediateSchema) error {
 
 // ValidURL checks, if a URL string is parseable.
 func ValidURL(is finc.IntermediateSchema) error {
+	var issues MultiIssue
 	for _, s := range is.URL {
 		if _, err := url.Parse(s); err != nil {
-			return Issue{Kind: InvalidURL, Record: is, Message: s}
+			issues = append(issues, Issue{Kind: InvalidURL, Record: is, Message: s})
 		}
 	}
-	return nil
+	return issues.Err()
 }
 
 // PlausibleDate checks for suspicious dates, refs. #5686.
@@ -147,29 +279,42 @@ func PlausiblePageCount(is finc.IntermediateSchema) error {
 		maxPageDigits = 6
 		maxPageCount  = 20000
 	)
+	var issues MultiIssue
+	var s, e int
+	var err error
+	startOK, endOK := true, true
 	if len(is.StartPage) > maxPageDigits {
-		return Issue{Kind: InvalidStartPage, Record: is, Message: is.StartPage}
+		issues = append(issues, Issue{Kind: InvalidStartPage, Record: is, Message: is.StartPage})
+		startOK = false
 	}
 	if len(is.EndPage) > maxPageDigits {
-		return Issue{Kind: InvalidEndPage, Record: is, Message: is.EndPage}
-	}
-	if is.StartPage != "" && is.EndPage != "" {
-		if s, err := strconv.Atoi(is.StartPage); err == nil {
-			if e, err := strconv.Atoi(is.EndPage); err == nil {
-				if e < s {
-					return Issue{Kind: EndPageBeforeStartPage, Record: is, Message: fmt.Sprintf("%v-%v", s, e)}
-				}
-				if e-s > maxPageCount {
-					return Issue{Kind: SuspiciousPageCount, Record: is, Message: fmt.Sprintf("%v-%v", s, e)}
-				}
-			} else {
-				return Issue{Kind: InvalidEndPage, Record: is, Message: is.EndPage}
-			}
-		} else {
-			return Issue{Kind: InvalidStartPage, Record: is, Message: is.StartPage}
+		issues = append(issues, Issue{Kind: InvalidEndPage, Record: is, Message: is.EndPage})
+		endOK = false
+	}
+	if is.StartPage == "" || is.EndPage == "" {
+		return issues.Err()
+	}
+	if startOK {
+		if s, err = strconv.Atoi(is.StartPage); err != nil {
+			issues = append(issues, Issue{Kind: InvalidStartPage, Record: is, Message: is.StartPage})
+			startOK = false
 		}
 	}
-	return nil
+	if endOK {
+		if e, err = strconv.Atoi(is.EndPage); err != nil {
+			issues = append(issues, Issue{Kind: InvalidEndPage, Record: is, Message: is.EndPage})
+			endOK = false
+		}
+	}
+	if startOK && endOK {
+		if e < s {
+			issues = append(issues, Issue{Kind: EndPageBeforeStartPage, Record: is, Message: fmt.Sprintf("%v-%v", s, e)})
+		}
+		if e-s > maxPageCount {
+			issues = append(issues, Issue{Kind: SuspiciousPageCount, Record: is, Message: fmt.Sprintf("%v-%v", s, e)})
+		}
+	}
+	return issues.Err()
 }
 
 // AllowedCollectionNames checks for a fixed list of allowed collection names,
@@ -237,26 +382,24 @@ func HasPublisher(is finc.IntermediateSchema) error {
 
 // FeasibleAuthor checks for a few suspicious authors patterns, refs. #4892, #4940, #5895.
 func FeasibleAuthor(is finc.IntermediateSchema) error {
+	var issues MultiIssue
 	for _, author := range is.Authors {
 		s := author.String()
-		if len(s) < 5 {
-			return Issue{Kind: ShortAuthorName, Record: is, Message: s}
-		}
 		lower := strings.ToLower(s)
-		if strings.HasPrefix(lower, "et al") {
-			return Issue{Kind: EtAlAuthorName, Record: is, Message: s}
-		}
-		if strings.Contains(lower, "&na;") {
-			return Issue{Kind: NAInAuthorName, Record: is, Message: s}
-		}
-		if len(s) > 0 && strings.TrimSpace(s) == "" {
-			return Issue{Kind: WhitespaceAuthor, Record: is, Message: "author contains whitespace only"}
-		}
-		if htmlEntityPattern.MatchString(s) {
-			return Issue{Kind: HTMLEntityInAuthorName, Record: is, Message: s}
+		switch {
+		case len(s) < 5:
+			issues = append(issues, Issue{Kind: ShortAuthorName, Record: is, Message: s})
+		case strings.HasPrefix(lower, "et al"):
+			issues = append(issues, Issue{Kind: EtAlAuthorName, Record: is, Message: s})
+		case strings.Contains(lower, "&na;"):
+			issues = append(issues, Issue{Kind: NAInAuthorName, Record: is, Message: s})
+		case len(s) > 0 && strings.TrimSpace(s) == "":
+			issues = append(issues, Issue{Kind: WhitespaceAuthor, Record: is, Message: "author contains whitespace only"})
+		case htmlEntityPattern.MatchString(s):
+			issues = append(issues, Issue{Kind: HTMLEntityInAuthorName, Record: is, Message: s})
 		}
 	}
-	return nil
+	return issues.Err()
 }
 
 // NoRepeatedSlash checks a DOI for repeated slashes, refs. #6312.
@@ -277,10 +420,274 @@ func HasURL(is finc.IntermediateSchema) error {
 
 // CanonicalISSN checks for the canonical ISSN format 1234-567X.
 func CanonicalISSN(is finc.IntermediateSchema) error {
+	var issues MultiIssue
 	for _, issn := range append(is.ISSN, is.EISSN...) {
 		if !issnPattern.MatchString(issn) {
-			return Issue{Kind: NonCanonicalISSN, Record: is, Message: issn}
+			issues = append(issues, Issue{Kind: NonCanonicalISSN, Record: is, Message: issn})
 		}
 	}
-	return nil
+	return issues.Err()
+}
+
+// normalizeISSN removes hyphens and spaces and uppercases the check digit, so
+// 1234-567x or 1234 567x become 1234567X.
+func normalizeISSN(s string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(s)))
+}
+
+// validISSNCheckDigit returns true, if a normalized, eight character ISSN has
+// the right mod 11 check digit.
+func validISSNCheckDigit(s string) bool {
+	var sum int
+	for i := 0; i < 7; i++ {
+		sum += int(s[i]-'0') * (8 - i)
+	}
+	var want byte
+	switch c := (11 - sum%11) % 11; c {
+	case 10:
+		want = 'X'
+	default:
+		want = byte('0' + c)
+	}
+	return s[7] == want
+}
+
+// ISSNCheckDigit verifies the check digit of ISSN and EISSN. Values, that do
+// not look like an ISSN at all are left to CanonicalISSN.
+func ISSNCheckDigit(is finc.IntermediateSchema) error {
+	var issues MultiIssue
+	for _, issn := range append(is.ISSN, is.EISSN...) {
+		s := normalizeISSN(issn)
+		if !issnLoosePattern.MatchString(s) {
+			continue
+		}
+		if !validISSNCheckDigit(s) {
+			issues = append(issues, Issue{Kind: InvalidISSNCheckDigit, Record: is, Message: issn})
+		}
+	}
+	return issues.Err()
+}
+
+// DistinctISSN checks, that no ISSN is listed as both print and electronic.
+func DistinctISSN(is finc.IntermediateSchema) error {
+	printed := make(map[string]bool)
+	for _, issn := range is.ISSN {
+		printed[normalizeISSN(issn)] = true
+	}
+	var issues MultiIssue
+	for _, issn := range is.EISSN {
+		if printed[normalizeISSN(issn)] {
+			issues = append(issues, Issue{Kind: ISSNPrintAndElectronic, Record: is, Message: issn})
+		}
+	}
+	return issues.Err()
+}
+
+// normalizeISBN removes hyphens and spaces and uppercases the check digit.
+func normalizeISBN(s string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(s)))
+}
+
+// isbn10CheckDigit computes the check digit for the first nine digits of an
+// ISBN-10.
+func isbn10CheckDigit(s string) byte {
+	var sum int
+	for i := 0; i < 9; i++ {
+		sum += int(s[i]-'0') * (10 - i)
+	}
+	switch c := (11 - sum%11) % 11; c {
+	case 10:
+		return 'X'
+	default:
+		return byte('0' + c)
+	}
+}
+
+// isbn13CheckDigit computes the check digit for the first twelve digits of an
+// ISBN-13.
+func isbn13CheckDigit(s string) byte {
+	var sum int
+	for i := 0; i < 12; i++ {
+		w := 1
+		if i%2 == 1 {
+			w = 3
+		}
+		sum += int(s[i]-'0') * w
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// isbn10To13 converts a normalized ISBN-10 into its ISBN-13 form.
+func isbn10To13(s string) string {
+	t := "978" + s[:9]
+	return t + string(isbn13CheckDigit(t))
+}
+
+// canonicalISBNHyphenation returns false for hyphenation, that cannot be
+// right, e.g. spaces, leading, trailing or double hyphens or a wrong number
+// of groups. ISBN without any hyphen are fine.
+func canonicalISBNHyphenation(s, normalized string) bool {
+	if strings.Contains(s, " ") || strings.Contains(s, "--") ||
+		strings.HasPrefix(s, "-") || strings.HasSuffix(s, "-") {
+		return false
+	}
+	switch n := strings.Count(s, "-"); {
+	case n == 0:
+		return true
+	case len(normalized) == 10:
+		return n == 3
+	default:
+		return n == 4
+	}
+}
+
+// ValidISBN checks syntax, check digit and hyphenation of ISBN and EISBN.
+func ValidISBN(is finc.IntermediateSchema) error {
+	var issues MultiIssue
+	for _, isbn := range append(is.ISBN, is.EISBN...) {
+		s := normalizeISBN(isbn)
+		switch {
+		case isbn10Pattern.MatchString(s):
+			if s[9] != isbn10CheckDigit(s) {
+				issues = append(issues, Issue{Kind: InvalidISBNCheckDigit, Record: is, Message: isbn})
+				continue
+			}
+		case isbn13Pattern.MatchString(s):
+			if s[12] != isbn13CheckDigit(s) {
+				issues = append(issues, Issue{Kind: InvalidISBNCheckDigit, Record: is, Message: isbn})
+				continue
+			}
+		default:
+			issues = append(issues, Issue{Kind: InvalidISBN, Record: is, Message: isbn})
+			continue
+		}
+		if !canonicalISBNHyphenation(strings.TrimSpace(isbn), s) {
+			issues = append(issues, Issue{Kind: NonCanonicalISBN, Record: is, Message: isbn})
+		}
+	}
+	return issues.Err()
+}
+
+// MatchingISBN checks, whether ISBN-10 and ISBN-13 given for the same medium
+// correspond to each other. Print and electronic ISBN are checked separately.
+// Invalid ISBN are left to ValidISBN.
+func MatchingISBN(is finc.IntermediateSchema) error {
+	var issues MultiIssue
+	for _, values := range [][]string{is.ISBN, is.EISBN} {
+		var isbn10 []string
+		isbn13 := make(map[string]bool)
+		for _, isbn := range values {
+			s := normalizeISBN(isbn)
+			switch {
+			case isbn10Pattern.MatchString(s) && s[9] == isbn10CheckDigit(s):
+				isbn10 = append(isbn10, isbn)
+			case isbn13Pattern.MatchString(s) && s[12] == isbn13CheckDigit(s):
+				isbn13[s] = true
+			}
+		}
+		if len(isbn10) == 0 || len(isbn13) == 0 {
+			continue
+		}
+		for _, isbn := range isbn10 {
+			if t := isbn10To13(normalizeISBN(isbn)); !isbn13[t] {
+				issues = append(issues, Issue{Kind: ISBNMismatch, Record: is,
+					Message: fmt.Sprintf("%s has no matching %s", isbn, t)})
+			}
+		}
+	}
+	return issues.Err()
+}
+
+// ValidDOI checks the syntax of a DOI. NoRepeatedSlash is a separate test.
+func ValidDOI(is finc.IntermediateSchema) error {
+	if is.DOI == "" {
+		return nil
+	}
+	var issues MultiIssue
+	doi := is.DOI
+	if loc := doiURLPrefixPattern.FindStringIndex(doi); loc != nil {
+		issues = append(issues, Issue{Kind: DOIWithURLPrefix, Record: is, Message: is.DOI})
+		doi = doi[loc[1]:]
+	}
+	if strings.IndexFunc(doi, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		issues = append(issues, Issue{Kind: WhitespaceInDOI, Record: is, Message: is.DOI})
+	}
+	if !doiPattern.MatchString(doi) {
+		issues = append(issues, Issue{Kind: InvalidDOIPrefix, Record: is, Message: is.DOI})
+	}
+	if doi == "" {
+		return issues.Err()
+	}
+	if strings.ContainsAny(doi[len(doi)-1:], ".,;:'\"") ||
+		(strings.HasSuffix(doi, ")") && strings.Count(doi, ")") > strings.Count(doi, "(")) {
+		issues = append(issues, Issue{Kind: TrailingPunctuationInDOI, Record: is, Message: is.DOI})
+	}
+	return issues.Err()
+}
+
+// DOIMatchesURL checks, whether DOI resolver links in URL point to the DOI of
+// the record. DOI are compared case insensitive.
+func DOIMatchesURL(is finc.IntermediateSchema) error {
+	var issues MultiIssue
+	for _, s := range is.URL {
+		loc := doiURLPrefixPattern.FindStringIndex(s)
+		if loc == nil || strings.HasPrefix(strings.ToLower(s), "doi:") {
+			continue
+		}
+		doi := s[loc[1]:]
+		if unescaped, err := url.PathUnescape(doi); err == nil {
+			doi = unescaped
+		}
+		if !strings.EqualFold(doi, is.DOI) {
+			issues = append(issues, Issue{Kind: DOIURLMismatch, Record: is,
+				Message: fmt.Sprintf("URL: %s, DOI: %s", s, is.DOI)})
+		}
+	}
+	return issues.Err()
+}
+
+// blockedHost returns true, if host or one of its parent domains is blocked.
+func blockedHost(host string) bool {
+	host = strings.ToLower(host)
+	for {
+		if BlockedURLHosts.Contains(host) {
+			return true
+		}
+		i := strings.Index(host, ".")
+		if i < 0 {
+			return false
+		}
+		host = host[i+1:]
+	}
+}
+
+// URLPolicy checks URLs against AllowedURLSchemes and BlockedURLHosts and
+// requires them to be absolute and unique. Unparsable URLs are left to
+// ValidURL.
+func URLPolicy(is finc.IntermediateSchema) error {
+	var issues MultiIssue
+	seen := make(map[string]bool)
+	for _, s := range is.URL {
+		if seen[s] {
+			issues = append(issues, Issue{Kind: DuplicateURL, Record: is, Message: s})
+			continue
+		}
+		seen[s] = true
+
+		u, err := url.Parse(s)
+		if err != nil {
+			continue
+		}
+		switch {
+		case u.Scheme == "":
+			issues = append(issues, Issue{Kind: NonAbsoluteURL, Record: is, Message: s})
+		case !AllowedURLSchemes.Contains(strings.ToLower(u.Scheme)):
+			issues = append(issues, Issue{Kind: DisallowedURLScheme, Record: is, Message: s})
+		case u.Host == "":
+			issues = append(issues, Issue{Kind: NonAbsoluteURL, Record: is, Message: s})
+		case blockedHost(u.Hostname()):
+			issues = append(issues, Issue{Kind: BlockedURLHost, Record: is, Message: s})
+		}
+	}
+	return issues.Err()
 }
@@ -0,0 +1,305 @@
+package istools
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/miku/span/finc"
+)
+
+func TestSelect(t *testing.T) {
+	var cases = []struct {
+		about   string
+		include []string
+		exclude []string
+		names   []string
+		allows  []Kind
+		denies  []Kind
+	}{
+		{
+			about:   "by test name",
+			include: []string{"CanonicalISSN", "PlausibleDate"},
+			names:   []string{"PlausibleDate", "CanonicalISSN"},
+			allows:  []Kind{NonCanonicalISSN, PublicationDateTooEarly},
+			denies:  []Kind{NoURL},
+		},
+		{
+			about:   "by kind name",
+			include: []string{"EtAlAuthorName"},
+			names:   []string{"FeasibleAuthor"},
+			allows:  []Kind{EtAlAuthorName},
+			denies:  []Kind{ShortAuthorName},
+		},
+		{
+			about:   "exclude kind",
+			include: []string{"FeasibleAuthor", "HasURL"},
+			exclude: []string{"NoURL", "ShortAuthorName"},
+			names:   []string{"FeasibleAuthor"},
+			allows:  []Kind{EtAlAuthorName},
+			denies:  []Kind{ShortAuthorName, NoURL},
+		},
+	}
+	for _, c := range cases {
+		s, err := Select(Registered(), c.include, c.exclude)
+		if err != nil {
+			t.Errorf("%s: got %v, want nil", c.about, err)
+			continue
+		}
+		if !reflect.DeepEqual(s.Names(), c.names) {
+			t.Errorf("%s: got %v, want %v", c.about, s.Names(), c.names)
+		}
+		for _, k := range c.allows {
+			if !s.Allows(k) {
+				t.Errorf("%s: %s not allowed", c.about, k)
+			}
+		}
+		for _, k := range c.denies {
+			if s.Allows(k) {
+				t.Errorf("%s: %s allowed", c.about, k)
+			}
+		}
+	}
+	if _, err := Select(Registered(), []string{"NoSuchTest"}, nil); err == nil {
+		t.Errorf("got nil, want error")
+	}
+}
+
+// issueKinds returns the kinds of the issues reported by a tester, failing
+// the test on unexpected error types.
+func issueKinds(t *testing.T, err error) []Kind {
+	var kinds []Kind
+	switch err := err.(type) {
+	case nil:
+	case Issue:
+		kinds = append(kinds, err.Kind)
+	case MultiIssue:
+		for _, issue := range err {
+			kinds = append(kinds, issue.Kind)
+		}
+	default:
+		t.Errorf("got %T, want Issue or MultiIssue", err)
+	}
+	return kinds
+}
+
+func TestMultiIssue(t *testing.T) {
+	var cases = []struct {
+		about string
+		test  TesterFunc
+		is    finc.IntermediateSchema
+		kinds []Kind
+	}{
+		{
+			about: "all broken ISSN",
+			test:  CanonicalISSN,
+			is:    finc.IntermediateSchema{ISSN: []string{"1234", "1234-5678"}, EISSN: []string{"12345678"}},
+			kinds: []Kind{NonCanonicalISSN, NonCanonicalISSN},
+		},
+		{
+			about: "all suspicious authors",
+			test:  FeasibleAuthor,
+			is: finc.IntermediateSchema{Authors: []finc.Author{
+				{Name: "Al"}, {Name: "Et al."}, {Name: "Martin Czygan"}, {Name: "J&amp;J Smith"}}},
+			kinds: []Kind{ShortAuthorName, EtAlAuthorName, HTMLEntityInAuthorName},
+		},
+		{
+			about: "both pages invalid",
+			test:  PlausiblePageCount,
+			is:    finc.IntermediateSchema{StartPage: "1234567", EndPage: "x"},
+			kinds: []Kind{InvalidStartPage, InvalidEndPage},
+		},
+		{
+			about: "both pages unparsable",
+			test:  PlausiblePageCount,
+			is:    finc.IntermediateSchema{StartPage: "a", EndPage: "b"},
+			kinds: []Kind{InvalidStartPage, InvalidEndPage},
+		},
+		{
+			about: "no issues",
+			test:  CanonicalISSN,
+			is:    finc.IntermediateSchema{ISSN: []string{"1234-5678"}},
+		},
+	}
+	for _, c := range cases {
+		err := c.test(c.is)
+		if _, ok := err.(Issue); ok {
+			t.Errorf("%s: got Issue, want MultiIssue", c.about)
+		}
+		kinds := issueKinds(t, err)
+		if !reflect.DeepEqual(kinds, c.kinds) {
+			t.Errorf("%s: got %v, want %v", c.about, kinds, c.kinds)
+		}
+	}
+}
+
+func TestDefaultFixers(t *testing.T) {
+	is := finc.IntermediateSchema{
+		RecordID:   "ai-1",
+		ISSN:       []string{"12345678", "1234-567x", "1234-5678", "invalid"},
+		DOI:        "10.123//abc///d",
+		Publishers: []string{"", "ACME", " "},
+		Authors: []finc.Author{
+			{Name: "        "}, {Name: "Et al."}, {Name: "J&amp;J Smith"}, {Name: "Martin Czygan"}},
+	}
+	var kinds []Kind
+	for _, f := range DefaultFixers {
+		for _, c := range f.FixRecord(&is) {
+			kinds = append(kinds, c.Kind)
+		}
+	}
+	want := []Kind{NonCanonicalISSN, NonCanonicalISSN, RepeatedSlash,
+		WhitespaceAuthor, EtAlAuthorName, HTMLEntityInAuthorName, NoPublisher, NoPublisher}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("got %v, want %v", kinds, want)
+	}
+	if want := []string{"1234-5678", "1234-567X", "1234-5678", "invalid"}; !reflect.DeepEqual(is.ISSN, want) {
+		t.Errorf("got %v, want %v", is.ISSN, want)
+	}
+	if want := "10.123/abc/d"; is.DOI != want {
+		t.Errorf("got %v, want %v", is.DOI, want)
+	}
+	if want := []string{"ACME"}; !reflect.DeepEqual(is.Publishers, want) {
+		t.Errorf("got %v, want %v", is.Publishers, want)
+	}
+	if len(is.Authors) != 2 || is.Authors[0].Name != "J&J Smith" {
+		t.Errorf("got %v, want two authors, first J&J Smith", is.Authors)
+	}
+}
+
+func TestChangeTSV(t *testing.T) {
+	c := Change{RecordID: "ai-1", Kind: HTMLEntityInAuthorName, Field: "authors",
+		Old: "A\tB\nC\\", New: "A B C"}
+	want := "ai-1\tHTMLEntityInAuthorName\tauthors\tA\\tB\\nC\\\\\tA B C"
+	if got := c.TSV(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestISSNCheckDigit(t *testing.T) {
+	var cases = []struct {
+		issn  string
+		valid bool
+	}{
+		{"0378-5955", true},
+		{"0317-8471", true},
+		{"2434-561X", true},
+		{"2434-561x", true},
+		{"1234-5678", false},
+		{"0378-5954", false},
+		{"0378 5955", true},
+		{"0378 5954", false},
+		{"garbage", true},
+	}
+	for _, c := range cases {
+		err := ISSNCheckDigit(finc.IntermediateSchema{ISSN: []string{c.issn}})
+		if valid := err == nil; valid != c.valid {
+			t.Errorf("%s: got %v, want %v", c.issn, valid, c.valid)
+		}
+	}
+	for _, eissn := range []string{"03785955", "0378 5955"} {
+		err := DistinctISSN(finc.IntermediateSchema{ISSN: []string{"0378-5955"}, EISSN: []string{eissn}})
+		if err == nil {
+			t.Errorf("%s: got nil, want ISSNPrintAndElectronic", eissn)
+		}
+	}
+}
+
+func TestISBN(t *testing.T) {
+	var cases = []struct {
+		about string
+		test  TesterFunc
+		is    finc.IntermediateSchema
+		kinds []Kind
+	}{
+		{
+			about: "valid",
+			test:  ValidISBN,
+			is:    finc.IntermediateSchema{ISBN: []string{"0-306-40615-2", "978-0-306-40615-7", "9780306406157", "080442957X"}},
+		},
+		{
+			about: "invalid",
+			test:  ValidISBN,
+			is:    finc.IntermediateSchema{ISBN: []string{"0-306-40615-3", "123", "978-0306-40615-7", "0 306 40615 2"}},
+			kinds: []Kind{InvalidISBNCheckDigit, InvalidISBN, NonCanonicalISBN, NonCanonicalISBN},
+		},
+		{
+			about: "matching pair",
+			test:  MatchingISBN,
+			is:    finc.IntermediateSchema{ISBN: []string{"0-306-40615-2", "978-0-306-40615-7"}},
+		},
+		{
+			about: "mismatching pair",
+			test:  MatchingISBN,
+			is:    finc.IntermediateSchema{ISBN: []string{"080442957X", "978-0-306-40615-7"}},
+			kinds: []Kind{ISBNMismatch},
+		},
+	}
+	for _, c := range cases {
+		kinds := issueKinds(t, c.test(c.is))
+		if !reflect.DeepEqual(kinds, c.kinds) {
+			t.Errorf("%s: got %v, want %v", c.about, kinds, c.kinds)
+		}
+	}
+}
+
+func TestDOI(t *testing.T) {
+	var cases = []struct {
+		about string
+		test  TesterFunc
+		is    finc.IntermediateSchema
+		kinds []Kind
+	}{
+		{"valid", ValidDOI, finc.IntermediateSchema{DOI: "10.1002/(SICI)1097-4571(199806)49:8<693::AID-ASI4>3.0.CO;2-O"}, nil},
+		{"no DOI", ValidDOI, finc.IntermediateSchema{}, nil},
+		{"prefix", ValidDOI, finc.IntermediateSchema{DOI: "11.1234/abc"}, []Kind{InvalidDOIPrefix}},
+		{"resolver", ValidDOI, finc.IntermediateSchema{DOI: "https://doi.org/10.1234/abc"}, []Kind{DOIWithURLPrefix}},
+		{"whitespace", ValidDOI, finc.IntermediateSchema{DOI: "10.1234/ab c"}, []Kind{WhitespaceInDOI}},
+		{"trailing", ValidDOI, finc.IntermediateSchema{DOI: "10.1234/abc."}, []Kind{TrailingPunctuationInDOI}},
+		{"only doi prefix", ValidDOI, finc.IntermediateSchema{DOI: "doi:"}, []Kind{DOIWithURLPrefix, InvalidDOIPrefix}},
+		{"only resolver", ValidDOI, finc.IntermediateSchema{DOI: "https://doi.org/"}, []Kind{DOIWithURLPrefix, InvalidDOIPrefix}},
+		{"url match", DOIMatchesURL, finc.IntermediateSchema{DOI: "10.1234/ABC", URL: []string{"http://dx.doi.org/10.1234/abc", "http://example.com"}}, nil},
+		{"url with plus", DOIMatchesURL, finc.IntermediateSchema{DOI: "10.1000/a+b", URL: []string{"https://doi.org/10.1000/a+b"}}, nil},
+		{"url with escaped slash", DOIMatchesURL, finc.IntermediateSchema{DOI: "10.1000/a/b", URL: []string{"https://doi.org/10.1000%2Fa%2Fb"}}, nil},
+		{"url mismatch", DOIMatchesURL, finc.IntermediateSchema{DOI: "10.1234/abc", URL: []string{"https://doi.org/10.1234/abd"}}, []Kind{DOIURLMismatch}},
+	}
+	for _, c := range cases {
+		kinds := issueKinds(t, c.test(c.is))
+		if !reflect.DeepEqual(kinds, c.kinds) {
+			t.Errorf("%s: got %v, want %v", c.about, kinds, c.kinds)
+		}
+	}
+}
+
+func TestURLPolicy(t *testing.T) {
+	is := finc.IntermediateSchema{URL: []string{
+		"http://example.com/a",
+		"https://example.com/b",
+		"/relative/path",
+		"javascript:alert(1)",
+		"http:///nohost",
+		"http://proxy.localhost/x",
+		"http://example.com/a",
+	}}
+	kinds := issueKinds(t, URLPolicy(is))
+	want := []Kind{NonAbsoluteURL, DisallowedURLScheme, NonAbsoluteURL, BlockedURLHost, DuplicateURL}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("got %v, want %v", kinds, want)
+	}
+}
+
+func TestIssueMarshalJSON(t *testing.T) {
+	issue := Issue{
+		Kind:    CurrencyInTitle,
+		Record:  finc.IntermediateSchema{RecordID: "ai-1", SourceID: "49", MegaCollection: "X"},
+		Message: "a\tb\n$90.00",
+	}
+	b, err := json.Marshal(issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":"ai-1","source":"49","collection":"X","kind":"CurrencyInTitle","severity":"warning","message":"a\tb\n$90.00"}`
+	if string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+}